@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -12,9 +13,10 @@ import (
 
 func main() {
 	// Windows Service mode: run CLI inside service handler (NO os.Exit here)
+	// SCM Stop/Shutdown cancels ctx, the app shuts down the same way as on SIGTERM
 	if service.IsRunningUnderWindowsService() {
-		service.RunUnderWindowsService(func() {
-			_ = entrypoint.Execute() // do not os.Exit() inside the service goroutine
+		service.RunUnderWindowsService(func(ctx context.Context) {
+			_ = entrypoint.Execute(ctx) // do not os.Exit() inside the service goroutine
 		})
 		return
 	}
@@ -28,5 +30,5 @@ func main() {
 		vars.Name, vars.CommitShort(), vars.Version, vars.Revision))
 
 	// Normal CLI execution path
-	os.Exit(entrypoint.Execute())
+	os.Exit(entrypoint.Execute(context.Background()))
 }
@@ -29,6 +29,15 @@ type BaseConfig struct {
 	// Print config to stdout: file at ConfigPath if exists+non-empty, otherwise embedded example-config.yaml.
 	PrintConfig bool `short:"p" long:"print-config" env:"METRICZ_CONFIG_PRINT" description:"Print embedded example config if missing/empty, else print path content"`
 
+	// Check a payload file with the ingest pipeline of the loaded config, print JSON report and exit.
+	ValidateFile string `long:"validate-file" description:"Validate ingest payload file (- for stdin), print JSON report and exit"`
+
+	// Instance ID the validated payload is ingested for, instance_id labels are not checked if empty.
+	ValidateInstance string `long:"validate-instance" description:"Instance ID for --validate-file"`
+
+	// Payload format of the validated file.
+	ValidateFormat string `long:"validate-format" description:"Payload format for --validate-file" choice:"text" choice:"openmetrics" choice:"protobuf" choice:"json" choice:"structured-json" choice:"influx" default:"text"`
+
 	// Version prints build info (version, commit, build date, etc.) and exits.
 	Version bool `short:"v" long:"version" description:"Print version and build info"`
 }
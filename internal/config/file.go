@@ -2,7 +2,9 @@ package config
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/creasty/defaults"
@@ -37,6 +39,9 @@ type AppConfig struct {
 	// ListenAddr is the TCP address the exporter listens on.
 	ListenAddr string `json:"listen_addr" default:":8098"`
 
+	// ShutdownTimeout bounds waiting for in-flight requests on shutdown.
+	ShutdownTimeout Duration `json:"shutdown_timeout" default:"15s"`
+
 	// GeoIP optionally enriches data with GeoIP database.
 	GeoIP GeoIPConfig `json:"geo_ip"`
 
@@ -46,6 +51,18 @@ type AppConfig struct {
 	// Public affects public endpoints behavior (currently cache TTL and CORS).
 	Public PublicConfig `json:"public"`
 
+	// StatsD configures the optional StatsD/DogStatsD UDP listener.
+	StatsD StatsDConfig `json:"statsd"`
+
+	// Snapshot persists live instance state across restarts.
+	Snapshot SnapshotConfig `json:"snapshot"`
+
+	// Reload controls reloading of the config file at runtime.
+	Reload ReloadConfig `json:"reload"`
+
+	// Admin controls the admin API for runtime server registration.
+	Admin AdminConfig `json:"admin"`
+
 	// Stale config defines when a server/metrics are considered stale/down.
 	Stale StaleConfig `json:"stale"`
 }
@@ -60,6 +77,27 @@ type AuthConfig struct {
 	Pass string `json:"password"`
 }
 
+// IngestAuthConfig holds per-instance ingest credentials, Basic Auth and/or Bearer token.
+type IngestAuthConfig struct {
+	// User is Basic Auth username for ingest endpoints of the instance.
+	User string `json:"user"`
+
+	// Pass is Basic Auth password for ingest endpoints of the instance.
+	Pass string `json:"password"`
+
+	// Token is accepted as "Authorization: Bearer <token>".
+	Token string `json:"token"`
+
+	// HMACSecret requires ingest requests of the instance to be signed with HMAC-SHA256.
+	// A signature alone authorizes the request if neither Basic Auth nor Token is set.
+	HMACSecret string `json:"hmac_secret"`
+}
+
+// HasCredentials reports whether Basic Auth or Bearer token credentials are configured.
+func (a *IngestAuthConfig) HasCredentials() bool {
+	return (a.User != "" && a.Pass != "") || a.Token != ""
+}
+
 // PublicConfig controls public endpoints behavior.
 type PublicConfig struct {
 	// PublicCacheTTL is TTL for cached /status responses (or underlying cached payload).
@@ -78,20 +116,176 @@ type IngestConfig struct {
 	// Applies to /ingest/{instance_id}/{txn_hash}/{seq_id} + /commit.
 	TransactionTTL Duration `json:"transaction_ttl" default:"15s"`
 
+	// CommitResultTTL is how long results of committed transactions are kept,
+	// a retried commit within it gets the original response instead of 404.
+	CommitResultTTL Duration `json:"commit_result_ttl" default:"5m"`
+
 	// GarbageCollectorTTL controls how often expired transactions are cleaned up.
 	GarbageCollectorTTL Duration `json:"gc_ttl" default:"60s"`
 
 	// MaxBodySize is max HTTP request body in bytes (hard limit).
 	MaxBodySize int64 `json:"max_body_size" default:"4194304"` // 4 MiB
 
-	// MaxStagingSize is the maximum allowed memory usage (in bytes) for incomplete transactions.
+	// MetricRelabelConfigs are applied to every ingested payload before per-server rules.
+	MetricRelabelConfigs []RelabelConfig `json:"metric_relabel_configs"`
+
+	// Limits bound cardinality of ingested payloads and instance state.
+	Limits IngestLimitsConfig `json:"limits"`
+
+	// RateLimit throttles ingest and commit requests and bounds concurrent payload parsing.
+	RateLimit IngestRateLimitConfig `json:"rate_limit"`
+
+	// AllowedInstances restricts instance IDs accepted by ingest endpoints.
+	AllowedInstances AllowedInstancesConfig `json:"allowed_instances"`
+
+	// MaxStagingSize is the maximum total size (in bytes) of incomplete transactions in any staging backend.
 	MaxStagingSize int64 `json:"max_staging_size" default:"67108864"` // 64 MiB
 
+	// MaxStagingSizePerInstance is the default staging quota (in bytes) of one instance,
+	// servers[].max_staging_size overrides it. Zero disables the quota, an instance may use
+	// the whole max_staging_size.
+	MaxStagingSizePerInstance int64 `json:"max_staging_size_per_instance"`
+
+	// StagingBackend keeps chunk data of incomplete transactions in "memory" or on "disk".
+	StagingBackend string `json:"staging_backend" default:"memory"`
+
+	// StagingDir is the directory for the disk staging backend, OS temp dir is used if empty.
+	StagingDir string `json:"staging_dir"`
+
+	// SignatureMaxSkew is the maximum difference between the signed request timestamp
+	// and exporter clock, applies to instances with ingest_auth.hmac_secret.
+	SignatureMaxSkew Duration `json:"signature_max_skew" default:"5m"`
+
 	// OverwriteInstanceID allows ingest payload to override instance_id label even
 	// if it differs from instance_id in URL.
 	OverwriteInstanceID bool `json:"overwrite_instance_id"`
 }
 
+// Staging backends of IngestConfig.
+const (
+	StagingBackendMemory = "memory"
+	StagingBackendDisk   = "disk"
+)
+
+// Policies applied when an ingest payload exceeds IngestLimitsConfig.
+const (
+	LimitPolicyReject   = "reject"
+	LimitPolicyTruncate = "truncate"
+)
+
+// IngestLimitsConfig controls cardinality limits of ingested metrics, 0 disables a limit.
+type IngestLimitsConfig struct {
+	// Policy is "reject" (whole payload refused with 422) or "truncate" (excess series dropped, rest accepted).
+	Policy string `json:"policy" default:"reject"`
+
+	// MaxFamilies is the maximum number of metric families in one payload.
+	MaxFamilies int `json:"max_families"`
+
+	// MaxSeriesPerFamily is the maximum number of series in one metric family.
+	MaxSeriesPerFamily int `json:"max_series_per_family"`
+
+	// MaxSeries is the maximum number of ingested series stored for one instance.
+	MaxSeries int `json:"max_series"`
+
+	// MaxLabelValueLength is the maximum length of a label value in bytes.
+	MaxLabelValueLength int `json:"max_label_value_length"`
+}
+
+// IngestRateLimitConfig controls backpressure of ingest requests, buckets are disabled by default.
+type IngestRateLimitConfig struct {
+	// PerInstance is the token bucket of one instance_id.
+	PerInstance RateLimitConfig `json:"per_instance" default:"{\"rate\": -1, \"burst\": 10}"`
+
+	// PerIP is the token bucket of one client IP address.
+	PerIP RateLimitConfig `json:"per_ip" default:"{\"rate\": -1, \"burst\": 50}"`
+
+	// ParseQueueTimeout is how long a request waits for a free parse slot before 503.
+	ParseQueueTimeout Duration `json:"parse_queue_timeout" default:"10s"`
+
+	// MaxConcurrentParses limits payloads parsed at the same time, 0 uses the number of CPUs.
+	MaxConcurrentParses int `json:"max_concurrent_parses"`
+}
+
+// RateLimitConfig is a token bucket, a negative rate disables the limit.
+type RateLimitConfig struct {
+	// Rate is the number of requests per second refilled into the bucket.
+	Rate float64 `json:"rate"`
+
+	// Burst is the bucket size, requests allowed at once.
+	Burst int `json:"burst"`
+}
+
+// Modes of AllowedInstancesConfig.
+const (
+	AllowInstancesAny        = "any"
+	AllowInstancesConfigured = "configured"
+	AllowInstancesList       = "list"
+)
+
+// AllowedInstancesConfig controls which instance IDs may push data.
+type AllowedInstancesConfig struct {
+	// Mode is "any" (every ID accepted), "configured" (IDs from servers plus IDs/Patterns)
+	// or "list" (only IDs/Patterns).
+	Mode string `json:"mode" default:"any"`
+
+	// IDs are explicitly allowed instance IDs.
+	IDs []string `json:"ids"`
+
+	// Patterns are anchored regular expressions of allowed instance IDs.
+	Patterns []Regexp `json:"patterns"`
+}
+
+// StatsDConfig controls the StatsD/DogStatsD UDP listener.
+type StatsDConfig struct {
+	// ListenAddr is the UDP address to listen on, empty disables the listener.
+	ListenAddr string `json:"listen_addr"`
+
+	// InstanceTag is the tag carrying instance_id of a sample, samples without it are dropped.
+	InstanceTag string `json:"instance_tag" default:"instance_id"`
+
+	// Prefix is prepended to names of aggregated metric families.
+	Prefix string `json:"prefix"`
+
+	// Buckets are histogram upper bounds of timers (in seconds) and histogram/distribution samples.
+	Buckets []float64 `json:"buckets" default:"[0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10]"`
+
+	// FlushInterval is how often aggregated samples are published to instance state.
+	FlushInterval Duration `json:"flush_interval" default:"10s"`
+
+	// SeriesMaxAge drops aggregated series not updated for longer, negative value disables expiration.
+	SeriesMaxAge Duration `json:"series_max_age" default:"1h"`
+
+	// MaxSeries is the maximum number of aggregated series of one instance, negative value disables the limit.
+	MaxSeries int `json:"max_series" default:"10000"`
+}
+
+// SnapshotConfig controls persistence of live instance state.
+type SnapshotConfig struct {
+	// DataDir is the directory of the state snapshot file, empty disables persistence.
+	DataDir string `json:"data_dir"`
+
+	// Interval is how often the snapshot is written, it is also written on shutdown.
+	Interval Duration `json:"interval" default:"1m"`
+}
+
+// ReloadConfig controls reloading of the config file, SIGHUP always reloads it.
+type ReloadConfig struct {
+	// WatchInterval is how often the config file is checked for changes.
+	WatchInterval Duration `json:"watch_interval" default:"10s"`
+
+	// Watch reloads the config file when its content changes.
+	Watch bool `json:"watch"`
+}
+
+// AdminConfig controls /api/v1/admin endpoints.
+type AdminConfig struct {
+	// Enabled exposes the admin API, it requires exporter.auth credentials.
+	Enabled bool `json:"enabled"`
+
+	// WriteBack writes servers changed through the admin API to the config file.
+	WriteBack bool `json:"write_back"`
+}
+
 // StaleConfig controls "staleness" detection.
 type StaleConfig struct {
 	// StaleMultiplier multiplies scrape/poll interval to decide "down".
@@ -100,6 +294,14 @@ type StaleConfig struct {
 
 	// MinStaleAge is the lower bound for stale marking regardless of multiplier.
 	MinStaleAge Duration `json:"min_age" default:"30s"`
+
+	// FamilyMaxAge expires ingested families not updated for longer than this age.
+	// Mostly relevant for merge ingest mode, negative value disables expiration.
+	FamilyMaxAge Duration `json:"family_max_age" default:"15m"`
+
+	// InstanceMaxAge evicts instances without ingest, poll or StatsD activity for longer than this age.
+	// Negative value disables eviction.
+	InstanceMaxAge Duration `json:"instance_max_age" default:"-1s"`
 }
 
 // GeoIPConfig points to GeoLite2/GeoIP2 database.
@@ -126,6 +328,10 @@ type PrometheusConfig struct {
 	// Disables the collector that exports metrics about the current state of the process, including
 	// CPU, memory, and file descriptor usage, as well as the process startup time.
 	DisableProcessCollector bool `json:"disable_process_collector"`
+
+	// Disable OpenMetrics negotiation on /metrics. When enabled (default), scrapers accepting
+	// OpenMetrics also receive "_created" series and exemplars of ingested metrics.
+	DisableOpenMetrics bool `json:"disable_openmetrics"`
 }
 
 // PublicExportConfig configures /status output.
@@ -148,9 +354,19 @@ type ServerDefinition struct {
 	// RCon is optional. If non-nil, exporter will connect to RCon endpoint for that instance.
 	RCon *RConConfig `json:"rcon,omitempty"`
 
+	// IngestAuth is optional. If non-nil, ingest endpoints of this instance accept only these
+	// credentials instead of the global exporter.auth ones.
+	IngestAuth *IngestAuthConfig `json:"ingest_auth,omitempty"`
+
 	// InstanceID is the stable logical id used in URLs and labels.
 	// Must be unique and non-empty.
 	InstanceID string `json:"instance_id"`
+
+	// MetricRelabelConfigs are applied to payloads ingested for this instance after global rules.
+	MetricRelabelConfigs []RelabelConfig `json:"metric_relabel_configs,omitempty"`
+
+	// MaxStagingSize overrides ingest.max_staging_size_per_instance for this instance.
+	MaxStagingSize int64 `json:"max_staging_size,omitempty"`
 }
 
 // A2SConfig configures A2S polling.
@@ -196,6 +412,19 @@ type RConConfig struct {
 
 // LoadConfig reads config from path (YAML/JSON), applies defaults, validates, and configures logger.
 func LoadConfig(path string) (*Config, error) {
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply logger configuration after validation
+	cfg.App.Logger.Setup()
+
+	return cfg, nil
+}
+
+// ReadConfig reads config from path (YAML/JSON), applies defaults and validates without touching logger.
+func ReadConfig(path string) (*Config, error) {
 	cfg := new(Config)
 
 	_, err := os.Stat(path)
@@ -217,18 +446,15 @@ func LoadConfig(path string) (*Config, error) {
 	}
 
 	// Validate logical constraints and required secrets
-	if err := cfg.validate(); err != nil {
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 
-	// Apply logger configuration after validation
-	cfg.App.Logger.Setup()
-
 	return cfg, nil
 }
 
-// validate checks configuration for logical errors
-func (cfg *Config) validate() error {
+// Validate checks configuration for logical errors.
+func (cfg *Config) Validate() error {
 	seenIDs := make(map[string]bool)
 
 	for i := range cfg.Servers {
@@ -257,15 +483,125 @@ func (cfg *Config) validate() error {
 				return fmt.Errorf("instance '%s': rcon enabled but password is empty", srv.InstanceID)
 			}
 		}
+
+		if srv.IngestAuth != nil && !srv.IngestAuth.HasCredentials() && srv.IngestAuth.HMACSecret == "" {
+			return fmt.Errorf("instance '%s': ingest_auth requires user and password, token or hmac_secret", srv.InstanceID)
+		}
+
+		where := fmt.Sprintf("instance '%s': metric_relabel_configs", srv.InstanceID)
+		if err := validateRelabelConfigs(where, srv.MetricRelabelConfigs); err != nil {
+			return err
+		}
 	}
 
 	if err := validateExtraLabels(cfg.App.Prometheus.ExtraLabels); err != nil {
 		return err
 	}
 
+	if err := validateRelabelConfigs("ingest.metric_relabel_configs", cfg.App.Ingest.MetricRelabelConfigs); err != nil {
+		return err
+	}
+
+	switch cfg.App.Ingest.AllowedInstances.Mode {
+	case AllowInstancesAny, AllowInstancesConfigured, AllowInstancesList:
+	default:
+		return fmt.Errorf("ingest.allowed_instances.mode: unknown mode %q", cfg.App.Ingest.AllowedInstances.Mode)
+	}
+
+	rateLimits := map[string]RateLimitConfig{
+		"ingest.rate_limit.per_instance": cfg.App.Ingest.RateLimit.PerInstance,
+		"ingest.rate_limit.per_ip":       cfg.App.Ingest.RateLimit.PerIP,
+	}
+	for where, limit := range rateLimits {
+		if limit.Rate > 0 && limit.Burst < 1 {
+			return fmt.Errorf("%s: burst must be at least 1", where)
+		}
+	}
+
+	switch cfg.App.Ingest.StagingBackend {
+	case StagingBackendMemory, StagingBackendDisk:
+	default:
+		return fmt.Errorf("ingest.staging_backend: unknown backend %q", cfg.App.Ingest.StagingBackend)
+	}
+
+	if cfg.App.StatsD.ListenAddr != "" {
+		if cfg.App.StatsD.FlushInterval <= 0 {
+			return fmt.Errorf("statsd.flush_interval must be positive")
+		}
+		for i, bound := range cfg.App.StatsD.Buckets {
+			if math.IsNaN(bound) || math.IsInf(bound, +1) || (i > 0 && bound <= cfg.App.StatsD.Buckets[i-1]) {
+				return fmt.Errorf("statsd.buckets must be finite and strictly increasing")
+			}
+		}
+	}
+
+	if cfg.App.Admin.Enabled && (cfg.App.Auth.User == "" || cfg.App.Auth.Pass == "") {
+		return fmt.Errorf("admin.enabled requires auth.user and auth.password")
+	}
+
+	if cfg.App.Reload.Watch && cfg.App.Reload.WatchInterval <= 0 {
+		return fmt.Errorf("reload.watch_interval must be positive")
+	}
+
+	if cfg.App.Snapshot.DataDir != "" && cfg.App.Snapshot.Interval <= 0 {
+		return fmt.Errorf("snapshot.interval must be positive")
+	}
+
+	switch cfg.App.Ingest.Limits.Policy {
+	case LimitPolicyReject, LimitPolicyTruncate:
+	default:
+		return fmt.Errorf("ingest.limits.policy: unknown policy %q", cfg.App.Ingest.Limits.Policy)
+	}
+
 	return nil
 }
 
+// Server returns the definition of the instance or nil if it is not configured.
+func (cfg *Config) Server(instanceID string) *ServerDefinition {
+	for i := range cfg.Servers {
+		if cfg.Servers[i].InstanceID == instanceID {
+			return &cfg.Servers[i]
+		}
+	}
+
+	return nil
+}
+
+// StagingQuota returns the staging quota (in bytes) of the instance.
+func (cfg *Config) StagingQuota(instanceID string) int64 {
+	if srv := cfg.Server(instanceID); srv != nil && srv.MaxStagingSize > 0 {
+		return srv.MaxStagingSize
+	}
+
+	return cfg.App.Ingest.MaxStagingSizePerInstance
+}
+
+// IngestAllowed reports whether ingest endpoints accept data for the instance ID.
+func (cfg *Config) IngestAllowed(instanceID string) bool {
+	allowed := cfg.App.Ingest.AllowedInstances
+
+	switch allowed.Mode {
+	case AllowInstancesAny:
+		return true
+	case AllowInstancesConfigured:
+		if cfg.Server(instanceID) != nil {
+			return true
+		}
+	}
+
+	if slices.Contains(allowed.IDs, instanceID) {
+		return true
+	}
+
+	for _, re := range allowed.Patterns {
+		if re.MatchString(instanceID) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // validateExtraLabels
 func validateExtraLabels(m map[string]string) error {
 	if len(m) == 0 {
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// Relabel actions supported by RelabelConfig.
+const (
+	RelabelReplace   = "replace"
+	RelabelKeep      = "keep"
+	RelabelDrop      = "drop"
+	RelabelHashMod   = "hashmod"
+	RelabelLabelDrop = "labeldrop"
+	RelabelLabelKeep = "labelkeep"
+)
+
+// RelabelConfig is a Prometheus-style metric relabeling rule applied to ingested series.
+// The metric name is available as the "__name__" pseudo label.
+type RelabelConfig struct {
+	// Replacement is the value written to TargetLabel by replace action, regex groups are expanded ($1, ${name}).
+	// Pointer distinguishes an omitted value ("$1") from an explicit empty one (deletes the target label).
+	Replacement *string `json:"replacement,omitempty"`
+
+	// Regex is matched against the joined SourceLabels values (or label names for labeldrop/labelkeep).
+	// It is anchored on both ends.
+	Regex Regexp `json:"regex" default:"(.*)"`
+
+	// Action is one of replace, keep, drop, hashmod, labeldrop, labelkeep.
+	Action string `json:"action" default:"replace"`
+
+	// Separator joins SourceLabels values.
+	Separator string `json:"separator" default:";"`
+
+	// TargetLabel receives the result of replace and hashmod actions.
+	TargetLabel string `json:"target_label"`
+
+	// SourceLabels selects label values concatenated with Separator.
+	SourceLabels []string `json:"source_labels"`
+
+	// Modulus is the divisor of the hashmod action.
+	Modulus uint64 `json:"modulus"`
+}
+
+// GetReplacement returns the replacement value with the "$1" default applied.
+func (rc *RelabelConfig) GetReplacement() string {
+	if rc.Replacement == nil {
+		return "$1"
+	}
+
+	return *rc.Replacement
+}
+
+// validate checks the rule is complete for its action.
+func (rc *RelabelConfig) validate() error {
+	switch rc.Action {
+	case RelabelReplace:
+		if rc.TargetLabel == "" {
+			return fmt.Errorf("relabel action %q requires target_label", rc.Action)
+		}
+		if !strings.Contains(rc.TargetLabel, "$") &&
+			!model.ValidationScheme.IsValidLabelName(model.UTF8Validation, rc.TargetLabel) {
+			return fmt.Errorf("relabel: invalid target_label %q", rc.TargetLabel)
+		}
+
+	case RelabelHashMod:
+		if rc.TargetLabel == "" {
+			return fmt.Errorf("relabel action %q requires target_label", rc.Action)
+		}
+		if rc.Modulus == 0 {
+			return fmt.Errorf("relabel action %q requires non-zero modulus", rc.Action)
+		}
+
+	case RelabelKeep, RelabelDrop:
+		if len(rc.SourceLabels) == 0 {
+			return fmt.Errorf("relabel action %q requires source_labels", rc.Action)
+		}
+
+	case RelabelLabelDrop, RelabelLabelKeep:
+		if len(rc.SourceLabels) != 0 || rc.TargetLabel != "" {
+			return fmt.Errorf("relabel action %q does not use source_labels and target_label", rc.Action)
+		}
+
+	default:
+		return fmt.Errorf("relabel: unknown action %q", rc.Action)
+	}
+
+	return nil
+}
+
+// validateRelabelConfigs checks every rule of the list, where is used in error messages.
+func validateRelabelConfigs(where string, cfgs []RelabelConfig) error {
+	for i := range cfgs {
+		if err := cfgs[i].validate(); err != nil {
+			return fmt.Errorf("%s[%d]: %w", where, i, err)
+		}
+	}
+
+	return nil
+}
+
+// Regexp is a wrapper around regexp.Regexp anchored on both ends,
+// it supports JSON string unmarshaling and the 'defaults' library.
+type Regexp struct {
+	*regexp.Regexp
+	original string
+}
+
+// NewRegexp compiles an anchored regular expression.
+func NewRegexp(expr string) (Regexp, error) {
+	re, err := regexp.Compile("^(?s:" + expr + ")$")
+	if err != nil {
+		return Regexp{}, err
+	}
+
+	return Regexp{Regexp: re, original: expr}, nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (re *Regexp) UnmarshalJSON(b []byte) error {
+	var expr string
+	if err := json.Unmarshal(b, &expr); err != nil {
+		return err
+	}
+
+	return re.UnmarshalText([]byte(expr))
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (re Regexp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(re.original)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+// This is required for the 'defaults' library to parse default tag strings like "(.*)".
+func (re *Regexp) UnmarshalText(text []byte) error {
+	compiled, err := NewRegexp(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid regex %q: %w", string(text), err)
+	}
+
+	*re = compiled
+	return nil
+}
+
+// String returns the original (not anchored) expression.
+func (re Regexp) String() string {
+	return re.original
+}
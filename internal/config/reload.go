@@ -0,0 +1,43 @@
+package config
+
+import "reflect"
+
+// RestartRequired returns settings changed between configs that are applied only on start,
+// so a reload keeps their previous values.
+func RestartRequired(previous, current *Config) []string {
+	prevApp, curApp := &previous.App, &current.App
+
+	checks := []struct {
+		name    string
+		changed bool
+	}{
+		{"listen_addr", prevApp.ListenAddr != curApp.ListenAddr},
+		{"shutdown_timeout", prevApp.ShutdownTimeout != curApp.ShutdownTimeout},
+		{"log.format", prevApp.Logger.Format != curApp.Logger.Format},
+		{"log.output", prevApp.Logger.Output != curApp.Logger.Output},
+		{"geo_ip", prevApp.GeoIP != curApp.GeoIP},
+		{"ingest.gc_ttl", prevApp.Ingest.GarbageCollectorTTL != curApp.Ingest.GarbageCollectorTTL},
+		{"ingest.staging_backend", prevApp.Ingest.StagingBackend != curApp.Ingest.StagingBackend},
+		{"ingest.staging_dir", prevApp.Ingest.StagingDir != curApp.Ingest.StagingDir},
+		{"prometheus.extra_labels", !reflect.DeepEqual(prevApp.Prometheus.ExtraLabels, curApp.Prometheus.ExtraLabels)},
+		{"prometheus.disable_go_collector", prevApp.Prometheus.DisableGoCollector != curApp.Prometheus.DisableGoCollector},
+		{"prometheus.disable_process_collector", prevApp.Prometheus.DisableProcessCollector != curApp.Prometheus.DisableProcessCollector},
+		{"statsd.listen_addr", prevApp.StatsD.ListenAddr != curApp.StatsD.ListenAddr},
+		{"statsd.prefix", prevApp.StatsD.Prefix != curApp.StatsD.Prefix},
+		{"statsd.buckets", !reflect.DeepEqual(prevApp.StatsD.Buckets, curApp.StatsD.Buckets)},
+		{"statsd.flush_interval", prevApp.StatsD.FlushInterval != curApp.StatsD.FlushInterval},
+		{"statsd.series_max_age", prevApp.StatsD.SeriesMaxAge != curApp.StatsD.SeriesMaxAge},
+		{"statsd.max_series", prevApp.StatsD.MaxSeries != curApp.StatsD.MaxSeries},
+		{"snapshot", prevApp.Snapshot != curApp.Snapshot},
+		{"reload", prevApp.Reload != curApp.Reload},
+	}
+
+	var changed []string
+	for _, check := range checks {
+		if check.changed {
+			changed = append(changed, check.name)
+		}
+	}
+
+	return changed
+}
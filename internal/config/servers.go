@@ -0,0 +1,282 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/creasty/defaults"
+	"gopkg.in/yaml.v3"
+)
+
+// secretPaths locate secret values in server mappings.
+var secretPaths = [][2]string{
+	{"rcon", "password"},
+	{"ingest_auth", "password"},
+	{"ingest_auth", "token"},
+	{"ingest_auth", "hmac_secret"},
+}
+
+// ParseServer decodes a JSON server definition and applies defaults, unknown fields are rejected.
+func ParseServer(data []byte) (ServerDefinition, error) {
+	var srv ServerDefinition
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&srv); err != nil {
+		return ServerDefinition{}, fmt.Errorf("invalid server definition: %w", err)
+	}
+
+	if err := defaults.Set(&srv); err != nil {
+		return ServerDefinition{}, err
+	}
+
+	return srv, nil
+}
+
+// WriteServers replaces the "servers" list of the YAML config file at path, other settings
+// and their comments are kept. Entries of servers equal to their previous definition are kept
+// as written (with comments and ${VAR} placeholders), new and changed ones are written with
+// resolved values except secrets: unchanged ones keep the value written in the file,
+// new or changed ones are written as ${METRICZ_SERVER_<ID>_<SECTION>_<KEY>} placeholders and returned
+// as warnings, the operator has to set them before the next reload. The file is replaced
+// atomically, a missing file is created.
+func WriteServers(path string, previous, servers []ServerDefinition) ([]string, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from CLI
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config file root is not a mapping")
+	}
+
+	// Nodes of servers as written in the file
+	var serversValue *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "servers" {
+			serversValue = root.Content[i+1]
+		}
+	}
+
+	written := make(map[string]*yaml.Node)
+	if serversValue != nil && serversValue.Kind == yaml.SequenceNode {
+		for _, node := range serversValue.Content {
+			if id := mappingValue(node, "instance_id"); id != "" {
+				written[id] = node
+			}
+		}
+	}
+
+	prevByID := make(map[string]ServerDefinition, len(previous))
+	for _, prev := range previous {
+		prevByID[prev.InstanceID] = prev
+	}
+
+	var warnings []string
+	seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, srv := range servers {
+		prev, existed := prevByID[srv.InstanceID]
+		if node, ok := written[srv.InstanceID]; ok && existed && reflect.DeepEqual(srv, prev) {
+			seq.Content = append(seq.Content, node)
+			continue
+		}
+
+		node, err := serverNode(srv)
+		if err != nil {
+			return nil, err
+		}
+
+		var prevNode *yaml.Node
+		if existed {
+			if prevNode, err = serverNode(prev); err != nil {
+				return nil, err
+			}
+		}
+		warnings = append(warnings, keepSecrets(srv.InstanceID, node, prevNode, written[srv.InstanceID])...)
+
+		seq.Content = append(seq.Content, node)
+	}
+
+	if serversValue != nil {
+		*serversValue = *seq
+	} else {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "servers"}, seq)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to encode config file: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return warnings, replaceFile(path, buf.Bytes())
+}
+
+// keepSecrets replaces resolved secrets of node with the values written in the file,
+// a secret is kept only if it equals the previous definition and the file has it.
+// Other secrets are replaced with ${VAR} placeholders, a warning is returned for each.
+func keepSecrets(instanceID string, node, prev, written *yaml.Node) []string {
+	var warnings []string
+	for _, path := range secretPaths {
+		value := mappingNode(mappingNode(node, path[0]), path[1])
+		if value == nil {
+			continue
+		}
+
+		var raw *yaml.Node
+		if old := mappingNode(mappingNode(prev, path[0]), path[1]); old != nil && old.Value == value.Value {
+			raw = mappingNode(mappingNode(written, path[0]), path[1])
+		}
+		if raw != nil && raw.Kind == yaml.ScalarNode {
+			*value = *raw
+			continue
+		}
+
+		env := secretEnvName(instanceID, path[0], path[1])
+		*value = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "${" + env + "}"}
+		warnings = append(warnings, fmt.Sprintf(
+			"%s.%s of server %q is written to the config file as ${%s}, set this variable before the next reload or restart",
+			path[0], path[1], instanceID, env))
+	}
+
+	return warnings
+}
+
+// secretEnvName returns the environment variable of a secret written back by WriteServers,
+// e.g. METRICZ_SERVER_1_RCON_PASSWORD, characters other than letters and digits become "_".
+func secretEnvName(instanceID, section, key string) string {
+	name := strings.ToUpper("METRICZ_SERVER_" + instanceID + "_" + section + "_" + key)
+
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// replaceFile writes data to a temporary file next to path and renames it over path,
+// readers never see a partially written file. The mode of an existing file is kept.
+func replaceFile(path string, data []byte) error {
+	mode := os.FileMode(0o600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// serverNode converts a server definition into a block style YAML mapping with instance_id first.
+func serverNode(srv ServerDefinition) (*yaml.Node, error) {
+	data, err := json.Marshal(srv)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is valid YAML, only the flow style has to be reset
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	node := doc.Content[0]
+	tidyNode(node)
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "instance_id" {
+			pair := []*yaml.Node{node.Content[i], node.Content[i+1]}
+			node.Content = append(pair, append(node.Content[:i:i], node.Content[i+2:]...)...)
+			break
+		}
+	}
+
+	return node, nil
+}
+
+// tidyNode switches flow collections to block style, scalars are quoted only where required,
+// and drops mapping keys with empty string values (unset secrets).
+func tidyNode(node *yaml.Node) {
+	node.Style = 0
+
+	if node.Kind == yaml.MappingNode {
+		content := node.Content[:0]
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value := node.Content[i+1]
+			if value.Kind == yaml.ScalarNode && value.Tag == "!!str" && value.Value == "" {
+				continue
+			}
+			content = append(content, node.Content[i], value)
+		}
+		node.Content = content
+	}
+
+	for _, child := range node.Content {
+		tidyNode(child)
+	}
+}
+
+// mappingValue returns the scalar value of key in a mapping node.
+func mappingValue(node *yaml.Node, key string) string {
+	if value := mappingNode(node, key); value != nil {
+		return value.Value
+	}
+
+	return ""
+}
+
+// mappingNode returns the value node of key in a mapping node, nil if node is nil or has no key.
+func mappingNode(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
@@ -6,14 +6,17 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
 	"github.com/rs/zerolog/log"
@@ -21,11 +24,13 @@ import (
 	"github.com/woozymasta/metricz-exporter/internal/config"
 	"github.com/woozymasta/metricz-exporter/internal/poller"
 	"github.com/woozymasta/metricz-exporter/internal/server"
+	"github.com/woozymasta/metricz-exporter/internal/statsd"
 	"github.com/woozymasta/metricz-exporter/internal/storage"
 )
 
 // Execute boots the application and returns an exit code.
-func Execute() int {
+// It runs until ctx is canceled or SIGINT/SIGTERM is received and then shuts down gracefully.
+func Execute(ctx context.Context) int {
 	// Parse command line flags
 	cliCfg := config.ParseFlags()
 
@@ -41,19 +46,53 @@ func Execute() int {
 		Int("servers_count", len(cfg.Servers)).
 		Msg("configuration loaded")
 
+	if cliCfg.ValidateFile != "" {
+		return validateFile(cfg, cliCfg)
+	}
+
 	// Initialize dependencies
-	store := storage.New(cfg.App.Ingest.MaxStagingSize)
+	var chunks storage.ChunkStore
+	if cfg.App.Ingest.StagingBackend == config.StagingBackendDisk {
+		diskStore, err := storage.NewDiskChunkStore(cfg.App.Ingest.StagingDir)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to init disk staging")
+			return 2
+		}
+		chunks = diskStore
+	}
+
+	store := storage.New(
+		cfg.App.Ingest.MaxStagingSize,
+		cfg.App.Stale.FamilyMaxAge.ToDuration(),
+		cfg.App.Stale.InstanceMaxAge.ToDuration(),
+		chunks)
+
+	// Restore live state saved by the previous run
+	var snapshotPath string
+	if cfg.App.Snapshot.DataDir != "" {
+		if err := os.MkdirAll(cfg.App.Snapshot.DataDir, 0o750); err != nil {
+			log.Error().Err(err).Str("path", cfg.App.Snapshot.DataDir).Msg("failed to create data dir")
+			return 2
+		}
+
+		snapshotPath = filepath.Join(cfg.App.Snapshot.DataDir, storage.SnapshotFile)
+		restored, err := store.LoadSnapshot(snapshotPath)
+		if err != nil {
+			// Broken snapshot must not block the start, it is overwritten by the next save
+			log.Error().Err(err).Str("path", snapshotPath).Msg("failed to restore state snapshot")
+		} else if restored > 0 {
+			log.Info().Str("path", snapshotPath).Int("instances", restored).Msg("state snapshot restored")
+		}
+	}
+
 	exporter := storage.NewExporter(store, cfg.App.Stale)
 	apiHandler := server.NewHandler(store, cfg)
 	pollerMgr := poller.NewManager(store, cfg)
+	defer pollerMgr.Close()
 
-	// Start Staging Garbage Collector
-	go store.StartGarbageCollector(context.Background(), cfg.App.Ingest.GarbageCollectorTTL.ToDuration())
-
-	// A2S/RCon poller
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	pollerMgr.Start(ctx)
+	// Root context of background workers, canceled on signal or by the caller (Windows service)
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Registry (implements both Registerer and Gatherer)
 	registry := prometheus.NewRegistry()
@@ -72,6 +111,24 @@ func Execute() int {
 		reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
 	}
 	reg.MustRegister(exporter)
+	reg.MustRegister(apiHandler.Collector())
+	store.OnInstanceRemoved(apiHandler.ForgetInstance)
+
+	// StatsD/DogStatsD listener
+	var statsdListener *statsd.Listener
+	if cfg.App.StatsD.ListenAddr != "" {
+		statsdListener, err = statsd.Listen(store, cfg)
+		if err != nil {
+			log.Error().Err(err).Str("address", cfg.App.StatsD.ListenAddr).Msg("failed to start StatsD listener")
+			return 2
+		}
+		reg.MustRegister(statsdListener)
+		store.OnInstanceRemoved(statsdListener.ForgetInstance)
+	}
+
+	// Config reload on SIGHUP and file changes
+	cfgReloader := newReloader(cliCfg.ConfigPath, cfg, apiHandler, exporter, store, pollerMgr, statsdListener)
+	reg.MustRegister(cfgReloader)
 
 	// Initialize Router
 	r := chi.NewRouter()
@@ -118,11 +175,13 @@ func Execute() int {
 	r.Route("/api/v1", func(r chi.Router) {
 		apiHandler.RegisterPublicRoutes(r)
 		r.Group(apiHandler.RegisterPrivateRoutes)
+		r.Group(apiHandler.RegisterInstanceRoutes)
+		r.Route("/admin", func(r chi.Router) { apiHandler.RegisterAdminRoutes(r, cfgReloader) })
 	})
 
 	// Prometheus Endpoint
 	r.With(apiHandler.BasicAuthMiddleware).
-		Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		Handle("/metrics", apiHandler.MetricsHandler(registry, reg))
 
 	log.Info().
 		Str("address", cfg.App.ListenAddr).
@@ -144,10 +203,57 @@ func Execute() int {
 		IdleTimeout:       60 * time.Second,
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
-		log.Fatal().Err(err).Msg("server failed")
-		return 3
+	// Background workers
+	var workers sync.WaitGroup
+	workers.Go(func() { store.StartGarbageCollector(ctx, cfg.App.Ingest.GarbageCollectorTTL.ToDuration()) })
+	if snapshotPath != "" {
+		workers.Go(func() { store.StartSnapshotter(ctx, snapshotPath, cfg.App.Snapshot.Interval.ToDuration()) })
 	}
+	if statsdListener != nil {
+		workers.Go(func() { statsdListener.Serve(ctx) })
+	}
+
+	// A2S/RCon poller
+	pollerMgr.Start(ctx)
+
+	// Reload needs started pollers to reconcile them
+	workers.Go(func() { cfgReloader.run(ctx) })
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		log.Error().Err(err).Msg("server failed")
+		exitCode = 3
+		stop()
+
+	case <-ctx.Done():
+		log.Info().Dur("timeout", cfg.App.ShutdownTimeout.ToDuration()).Msg("stopping metricz-exporter")
+
+		// In-flight ingest and commit requests are completed before the state is saved
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.App.ShutdownTimeout.ToDuration())
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Warn().Err(err).Msg("HTTP server did not drain in time, closing connections")
+			_ = srv.Close()
+		}
+		cancel()
+	}
+
+	// Pollers close RCon sessions on exit, GeoIP reader is closed by deferred pollerMgr.Close
+	pollerMgr.Wait()
+	workers.Wait()
+
+	if snapshotPath != "" {
+		if err := store.SaveSnapshot(snapshotPath); err != nil {
+			log.Error().Err(err).Str("path", snapshotPath).Msg("failed to save state snapshot")
+		} else {
+			log.Info().Str("path", snapshotPath).Msg("state snapshot saved")
+		}
+	}
+
+	log.Info().Msg("metricz-exporter stopped")
 
-	return 0
+	return exitCode
 }
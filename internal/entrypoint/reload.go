@@ -0,0 +1,233 @@
+package entrypoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+
+	"github.com/woozymasta/metricz-exporter/internal/config"
+	"github.com/woozymasta/metricz-exporter/internal/poller"
+	"github.com/woozymasta/metricz-exporter/internal/server"
+	"github.com/woozymasta/metricz-exporter/internal/statsd"
+	"github.com/woozymasta/metricz-exporter/internal/storage"
+)
+
+// reloader re-reads the config file on SIGHUP or when the watched file changes
+// and applies it to running components without restart.
+type reloader struct {
+	started     *config.Config
+	cfg         *config.Config
+	handler     *server.Handler
+	exporter    *storage.Exporter
+	store       *storage.Storage
+	pollers     *poller.Manager
+	statsd      *statsd.Listener
+	reloads     *prometheus.CounterVec
+	lastSuccess prometheus.Gauge
+	path        string
+	fileHash    uint64
+	mu          sync.Mutex
+}
+
+func newReloader(
+	path string,
+	cfg *config.Config,
+	handler *server.Handler,
+	exporter *storage.Exporter,
+	store *storage.Storage,
+	pollers *poller.Manager,
+	statsdListener *statsd.Listener,
+) *reloader {
+	r := &reloader{
+		path:     path,
+		started:  cfg,
+		cfg:      cfg,
+		handler:  handler,
+		exporter: exporter,
+		store:    store,
+		pollers:  pollers,
+		statsd:   statsdListener,
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "metricz_config_reloads_total",
+			Help: "Total config reloads by result.",
+		}, []string{"result"}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "metricz_config_last_reload_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful config load.",
+		}),
+	}
+	r.fileHash, _ = hashFile(path)
+	r.lastSuccess.SetToCurrentTime()
+
+	return r
+}
+
+// Describe implements prometheus.Collector.
+func (r *reloader) Describe(ch chan<- *prometheus.Desc) {
+	r.reloads.Describe(ch)
+	r.lastSuccess.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (r *reloader) Collect(ch chan<- prometheus.Metric) {
+	r.reloads.Collect(ch)
+	r.lastSuccess.Collect(ch)
+}
+
+// run reloads the config on SIGHUP, and on file changes if watching is enabled, until ctx is canceled.
+func (r *reloader) run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var watch <-chan time.Time
+	if r.cfg.App.Reload.Watch {
+		ticker := time.NewTicker(r.cfg.App.Reload.WatchInterval.ToDuration())
+		defer ticker.Stop()
+		watch = ticker.C
+
+		log.Info().
+			Str("path", r.path).
+			Dur("interval", r.cfg.App.Reload.WatchInterval.ToDuration()).
+			Msg("watching config file for changes")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hup:
+			r.reload("signal")
+
+		case <-watch:
+			hash, err := hashFile(r.path)
+			if err != nil || hash == r.fileHash {
+				continue
+			}
+			r.reload("watch")
+		}
+	}
+}
+
+// reload loads the config file and applies it, a broken config keeps the running one.
+func (r *reloader) reload(trigger string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Even a failed attempt is remembered, the watcher retries only after the next change
+	r.fileHash, _ = hashFile(r.path)
+
+	cfg, err := r.load()
+	if err != nil {
+		r.reloads.WithLabelValues("failure").Inc()
+		log.Error().Err(err).Str("path", r.path).Str("trigger", trigger).Msg("config reload failed, keeping running config")
+		return
+	}
+
+	// Compared with the config of start, the warning repeats on every reload until restart
+	if restart := config.RestartRequired(r.started, cfg); len(restart) > 0 {
+		log.Warn().
+			Str("settings", strings.Join(restart, ", ")).
+			Msg("changed settings require restart, previous values stay in effect")
+	}
+
+	r.apply(cfg)
+	r.reloads.WithLabelValues("success").Inc()
+	r.lastSuccess.SetToCurrentTime()
+
+	log.Info().
+		Str("path", r.path).
+		Str("trigger", trigger).
+		Int("servers_count", len(cfg.Servers)).
+		Msg("configuration reloaded")
+}
+
+// UpdateServers implements server.ServerRegistry, servers changed through the admin API are
+// applied first and then written to the config file with admin.write_back, otherwise the next
+// reload discards them. Write-back problems do not undo the change, they are returned as warnings.
+func (r *reloader) UpdateServers(update func([]config.ServerDefinition) ([]config.ServerDefinition, error)) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	servers, err := update(slices.Clone(r.cfg.Servers))
+	if err != nil {
+		return nil, err
+	}
+
+	previous := r.cfg.Servers
+	cfg := *r.cfg
+	cfg.Servers = servers
+	r.apply(&cfg)
+
+	if !cfg.App.Admin.WriteBack {
+		return nil, nil
+	}
+
+	warnings, err := config.WriteServers(r.path, previous, servers)
+	if err != nil {
+		log.Error().Err(err).Str("path", r.path).Msg("failed to write servers to config file, change is applied in memory only")
+		return []string{"change is applied but not written to the config file: " + err.Error()}, nil
+	}
+
+	// Own changes must not trigger the watcher
+	r.fileHash, _ = hashFile(r.path)
+
+	for _, warning := range warnings {
+		log.Warn().Str("path", r.path).Msg(warning)
+	}
+
+	return warnings, nil
+}
+
+// load reads the config file, unlike on start a missing file is an error,
+// otherwise a deleted file would silently reset everything to defaults.
+func (r *reloader) load() (*config.Config, error) {
+	if _, err := os.Stat(r.path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("config file not found: %w", err)
+		}
+		return nil, err
+	}
+
+	return config.ReadConfig(r.path)
+}
+
+// apply swaps the config of running components and reconciles pollers.
+// Must be called under mu.
+func (r *reloader) apply(cfg *config.Config) {
+	cfg.App.Logger.SetupLevel()
+	r.handler.UpdateConfig(cfg)
+	r.exporter.UpdateStaleConfig(cfg.App.Stale)
+	r.store.SetLimits(
+		cfg.App.Ingest.MaxStagingSize,
+		cfg.App.Stale.FamilyMaxAge.ToDuration(),
+		cfg.App.Stale.InstanceMaxAge.ToDuration())
+	if r.statsd != nil {
+		r.statsd.UpdateConfig(cfg)
+	}
+	r.pollers.Reconcile(cfg.Servers)
+
+	r.cfg = cfg
+}
+
+// hashFile returns the hash of the file content.
+func hashFile(path string) (uint64, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from CLI
+	if err != nil {
+		return 0, err
+	}
+
+	return xxhash.Sum64(data), nil
+}
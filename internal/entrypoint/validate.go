@@ -0,0 +1,57 @@
+package entrypoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/woozymasta/metricz-exporter/internal/config"
+	"github.com/woozymasta/metricz-exporter/internal/parser"
+	"github.com/woozymasta/metricz-exporter/internal/server"
+	"github.com/woozymasta/metricz-exporter/internal/storage"
+)
+
+// validateFile runs the payload file through the ingest pipeline and prints the JSON report.
+// Returns 0 if the payload would be accepted, 1 if not and 2 on errors.
+func validateFile(cfg *config.Config, cliCfg *config.BaseConfig) int {
+	var input io.ReadCloser = os.Stdin
+	if cliCfg.ValidateFile != "-" {
+		f, err := os.Open(cliCfg.ValidateFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open payload: %v\n", err)
+			return 2
+		}
+		input = f
+	}
+	defer func() { _ = input.Close() }()
+
+	format := parser.FormatText
+	switch cliCfg.ValidateFormat {
+	case "openmetrics":
+		format = parser.FormatOpenMetrics
+	case "protobuf":
+		format = parser.FormatProtoDelim
+	case "structured-json":
+		format = parser.FormatJSON
+	case "influx":
+		format = parser.FormatInflux
+	}
+
+	// Empty storage, validation only reads it
+	handler := server.NewHandler(storage.New(cfg.App.Ingest.MaxStagingSize, 0, 0, nil), cfg)
+	report := handler.ValidatePayload(input, cliCfg.ValidateInstance, format, cliCfg.ValidateFormat == "json")
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+		return 2
+	}
+
+	if !report.Valid {
+		return 1
+	}
+
+	return 0
+}
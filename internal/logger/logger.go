@@ -37,13 +37,7 @@ func (l *Logger) Setup() {
 		l.Output = "stderr"
 	}
 
-	// level
-	level, err := zerolog.ParseLevel(l.Level)
-	if err != nil {
-		level = zerolog.InfoLevel
-	}
-
-	zerolog.SetGlobalLevel(level)
+	l.SetupLevel()
 
 	// writer
 	var writer io.Writer
@@ -80,3 +74,13 @@ func (l *Logger) Setup() {
 		log.Logger = log.Output(consoleWriter)
 	}
 }
+
+// SetupLevel applies only the logging level, output and format are kept.
+func (l *Logger) SetupLevel() {
+	level, err := zerolog.ParseLevel(l.Level)
+	if err != nil {
+		level = zerolog.InfoLevel
+	}
+
+	zerolog.SetGlobalLevel(level)
+}
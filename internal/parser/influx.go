@@ -0,0 +1,311 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// influxDecoder adapts InfluxDB line protocol payloads to familyDecoder.
+type influxDecoder struct {
+	input    io.Reader
+	err      error
+	families []*dto.MetricFamily
+	parsed   bool
+}
+
+func newInfluxDecoder(input io.Reader) *influxDecoder {
+	return &influxDecoder{input: input}
+}
+
+func (d *influxDecoder) next() (*dto.MetricFamily, error) {
+	if !d.parsed {
+		d.families, d.err = parseInflux(d.input)
+		d.parsed = true
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.families) == 0 {
+		return nil, io.EOF
+	}
+
+	mf := d.families[0]
+	d.families = d.families[1:]
+
+	return mf, nil
+}
+
+// influxField is a tag or field key with its value and 1-based column,
+// tag values are unescaped while field values are kept raw.
+type influxField struct {
+	key    string
+	value  string
+	column int
+}
+
+// parseInflux converts InfluxDB line protocol into untyped families.
+// Every numeric or boolean field becomes a series of the "<measurement>_<field>" family
+// ("<measurement>" for the "value" field) with tags as labels, string fields are skipped.
+// Names are sanitized to the Prometheus charset, tag keys colliding after that are rejected, timestamps are ignored
+// as for other ingested data the exporter serves current values.
+func parseInflux(input io.Reader) ([]*dto.MetricFamily, error) {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	families := make(map[string]*dto.MetricFamily)
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		measurement, tags, fields, err := splitInfluxLine(line)
+		if err != nil {
+			err.Line = lineNo
+			return nil, err
+		}
+
+		// Sanitizing may map distinct keys ("a-b", "a_b") to one label, duplicate labels break the exposition
+		labels := make([]*dto.LabelPair, 0, len(tags))
+		seen := make(map[string]struct{}, len(tags))
+		for _, tag := range tags {
+			name, value := SanitizeName(tag.key), tag.value
+			if _, dup := seen[name]; dup {
+				return nil, &SyntaxError{Line: lineNo, Column: tag.column, Msg: fmt.Sprintf("tag %q collides with another tag as label %q", tag.key, name)}
+			}
+			seen[name] = struct{}{}
+			labels = append(labels, &dto.LabelPair{Name: &name, Value: &value})
+		}
+
+		for _, field := range fields {
+			value, ok, err := parseInfluxValue(field.value)
+			if err != nil {
+				return nil, &SyntaxError{Line: lineNo, Column: field.column, Msg: err.Error()}
+			}
+			if !ok {
+				continue
+			}
+
+			name := SanitizeName(measurement)
+			if field.key != "value" {
+				name += "_" + SanitizeName(field.key)
+			}
+
+			mf, exists := families[name]
+			if !exists {
+				typ := dto.MetricType_UNTYPED
+				mf = &dto.MetricFamily{Name: &name, Type: &typ}
+				families[name] = mf
+			}
+
+			mf.Metric = append(mf.Metric, &dto.Metric{
+				Label:   copyLabels(labels),
+				Untyped: &dto.Untyped{Value: &value},
+			})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading influx payload: %w", err)
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		result = append(result, families[name])
+	}
+
+	return result, nil
+}
+
+// splitInfluxLine splits a line into measurement, tags and fields, the timestamp is validated only.
+func splitInfluxLine(line string) (string, []influxField, []influxField, *SyntaxError) {
+	keyEnd := indexUnescaped(line, 0, ' ', false)
+	if keyEnd < 0 {
+		return "", nil, nil, &SyntaxError{Column: len(line) + 1, Msg: "missing fields"}
+	}
+
+	fieldsStart := skipSpaces(line, keyEnd)
+	fieldsEnd := indexUnescaped(line, fieldsStart, ' ', true)
+	if fieldsEnd < 0 {
+		fieldsEnd = len(line)
+	}
+
+	if tsStart := skipSpaces(line, fieldsEnd); tsStart < len(line) {
+		if _, err := strconv.ParseInt(line[tsStart:], 10, 64); err != nil {
+			return "", nil, nil, &SyntaxError{Column: tsStart + 1, Msg: fmt.Sprintf("invalid timestamp %q", line[tsStart:])}
+		}
+	}
+
+	// Measurement and tags
+	var tags []influxField
+	parts := splitUnescaped(line[:keyEnd], 0, ',', false)
+	measurement := unescapeInflux(line[parts[0][0]:parts[0][1]])
+	if measurement == "" {
+		return "", nil, nil, &SyntaxError{Column: 1, Msg: "empty measurement"}
+	}
+
+	for _, part := range parts[1:] {
+		text := line[part[0]:part[1]]
+		eq := indexUnescaped(text, 0, '=', false)
+		if eq <= 0 || eq == len(text)-1 {
+			return "", nil, nil, &SyntaxError{Column: part[0] + 1, Msg: fmt.Sprintf("invalid tag %q", text)}
+		}
+		tags = append(tags, influxField{
+			key:    unescapeInflux(text[:eq]),
+			value:  unescapeInflux(text[eq+1:]),
+			column: part[0] + 1,
+		})
+	}
+
+	// Fields
+	var fields []influxField
+	for _, part := range splitUnescaped(line[:fieldsEnd], fieldsStart, ',', true) {
+		text := line[part[0]:part[1]]
+		eq := indexUnescaped(text, 0, '=', false)
+		if eq <= 0 || eq == len(text)-1 {
+			return "", nil, nil, &SyntaxError{Column: part[0] + 1, Msg: fmt.Sprintf("invalid field %q", text)}
+		}
+		fields = append(fields, influxField{
+			key:    unescapeInflux(text[:eq]),
+			value:  text[eq+1:],
+			column: part[0] + eq + 2,
+		})
+	}
+
+	return measurement, tags, fields, nil
+}
+
+// parseInfluxValue converts a field value to float, ok is false for string fields.
+func parseInfluxValue(raw string) (float64, bool, error) {
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+
+	if strings.HasPrefix(raw, `"`) {
+		if len(raw) < 2 || !strings.HasSuffix(raw, `"`) {
+			return 0, false, fmt.Errorf("unterminated string field value")
+		}
+		return 0, false, nil
+	}
+
+	number := raw
+	if strings.HasSuffix(raw, "i") || strings.HasSuffix(raw, "u") {
+		number = raw[:len(raw)-1]
+		if _, err := strconv.ParseInt(number, 10, 64); err != nil {
+			if _, err := strconv.ParseUint(number, 10, 64); err != nil {
+				return 0, false, fmt.Errorf("invalid integer field value %q", raw)
+			}
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid field value %q", raw)
+	}
+
+	return value, true, nil
+}
+
+// indexUnescaped returns the index of the first sep from start not escaped by a backslash
+// (and not inside a double-quoted string if quotes is set), or -1.
+func indexUnescaped(s string, start int, sep byte, quotes bool) int {
+	inQuotes := false
+	for i := start; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			return i
+		}
+	}
+
+	return -1
+}
+
+// splitUnescaped splits s[start:] by unescaped sep and returns [from, to) index pairs.
+func splitUnescaped(s string, start int, sep byte, quotes bool) [][2]int {
+	var parts [][2]int
+	for {
+		end := indexUnescaped(s, start, sep, quotes)
+		if end < 0 {
+			return append(parts, [2]int{start, len(s)})
+		}
+
+		parts = append(parts, [2]int{start, end})
+		start = end + 1
+	}
+}
+
+// skipSpaces returns the index of the first non-space byte from start.
+func skipSpaces(s string, start int) int {
+	for start < len(s) && s[start] == ' ' {
+		start++
+	}
+
+	return start
+}
+
+// unescapeInflux removes backslashes escaping commas, spaces, equal signs and backslashes.
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`, =\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// SanitizeName replaces characters outside of [a-zA-Z0-9_] with underscores,
+// a leading digit is prefixed with an underscore.
+func SanitizeName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' {
+			b[i] = '_'
+		}
+	}
+
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+
+	return string(b)
+}
+
+// copyLabels returns a copy of label pairs, every series needs its own slice.
+func copyLabels(labels []*dto.LabelPair) []*dto.LabelPair {
+	out := make([]*dto.LabelPair, len(labels))
+	for i, lp := range labels {
+		name, value := lp.GetName(), lp.GetValue()
+		out[i] = &dto.LabelPair{Name: &name, Value: &value}
+	}
+
+	return out
+}
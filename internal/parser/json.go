@@ -0,0 +1,460 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// JSONError describes an invalid value of a structured JSON payload.
+type JSONError struct {
+	// Path locates the value, e.g. "families[1].samples[0].value".
+	Path string
+	Msg  string
+}
+
+// Error implements error.
+func (e *JSONError) Error() string {
+	if e.Path == "" {
+		return e.Msg
+	}
+
+	return e.Path + ": " + e.Msg
+}
+
+// jsonPayload is the structured JSON ingest format:
+//
+//	{"families": [{
+//	  "name": "dayz_metricz_players_online", "help": "Players online", "type": "gauge",
+//	  "samples": [{"labels": {"map": "chernarus"}, "value": 42}]
+//	}]}
+//
+// Histogram and gauge histogram samples carry "count", "sum" and cumulative "buckets"
+// by upper bound ({"0.5": 3, "+Inf": 10}), summary samples "count", "sum" and "quantiles".
+type jsonPayload struct {
+	Families []json.RawMessage `json:"families"`
+}
+
+type jsonFamily struct {
+	Name    json.RawMessage   `json:"name"`
+	Help    json.RawMessage   `json:"help"`
+	Type    json.RawMessage   `json:"type"`
+	Unit    json.RawMessage   `json:"unit"`
+	Samples []json.RawMessage `json:"samples"`
+}
+
+type jsonSample struct {
+	Labels      map[string]json.RawMessage `json:"labels"`
+	Buckets     map[string]json.RawMessage `json:"buckets"`
+	Quantiles   map[string]json.RawMessage `json:"quantiles"`
+	Value       json.RawMessage            `json:"value"`
+	Count       json.RawMessage            `json:"count"`
+	Sum         json.RawMessage            `json:"sum"`
+	TimestampMs json.RawMessage            `json:"timestamp_ms"`
+}
+
+// jsonTypes maps accepted "type" values to metric types.
+var jsonTypes = map[string]dto.MetricType{
+	"":                dto.MetricType_UNTYPED,
+	"untyped":         dto.MetricType_UNTYPED,
+	"unknown":         dto.MetricType_UNTYPED,
+	"counter":         dto.MetricType_COUNTER,
+	"gauge":           dto.MetricType_GAUGE,
+	"histogram":       dto.MetricType_HISTOGRAM,
+	"gaugehistogram":  dto.MetricType_GAUGE_HISTOGRAM,
+	"gauge_histogram": dto.MetricType_GAUGE_HISTOGRAM,
+	"summary":         dto.MetricType_SUMMARY,
+}
+
+// jsonDecoder adapts structured JSON payloads to familyDecoder.
+type jsonDecoder struct {
+	input    io.Reader
+	err      error
+	families []*dto.MetricFamily
+	parsed   bool
+}
+
+func newJSONDecoder(input io.Reader) *jsonDecoder {
+	return &jsonDecoder{input: input}
+}
+
+func (d *jsonDecoder) next() (*dto.MetricFamily, error) {
+	if !d.parsed {
+		d.families, d.err = parseJSON(d.input)
+		d.parsed = true
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.families) == 0 {
+		return nil, io.EOF
+	}
+
+	mf := d.families[0]
+	d.families = d.families[1:]
+
+	return mf, nil
+}
+
+// parseJSON converts a structured JSON payload into client model families.
+// Numbers may be sent as JSON numbers or strings ("NaN", "+Inf"), label values
+// as strings, numbers or booleans. Errors are reported with the JSON path of the value.
+func parseJSON(input io.Reader) ([]*dto.MetricFamily, error) {
+	var payload jsonPayload
+	if err := decodeJSON(input, "", &payload); err != nil {
+		return nil, err
+	}
+
+	result := make([]*dto.MetricFamily, 0, len(payload.Families))
+	seen := make(map[string]bool, len(payload.Families))
+
+	for i, raw := range payload.Families {
+		path := fmt.Sprintf("families[%d]", i)
+		mf, err := parseJSONFamily(path, raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[mf.GetName()] {
+			return nil, &JSONError{Path: path + ".name", Msg: fmt.Sprintf("metric family %q is declared twice", mf.GetName())}
+		}
+		seen[mf.GetName()] = true
+
+		result = append(result, mf)
+	}
+
+	return result, nil
+}
+
+func parseJSONFamily(path string, raw json.RawMessage) (*dto.MetricFamily, error) {
+	var fam jsonFamily
+	if err := decodeJSON(bytes.NewReader(raw), path, &fam); err != nil {
+		return nil, err
+	}
+
+	name, err := jsonString(path+".name", fam.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !model.ValidationScheme.IsValidMetricName(model.UTF8Validation, name) {
+		return nil, &JSONError{Path: path + ".name", Msg: fmt.Sprintf("invalid metric name %q", name)}
+	}
+
+	typeName, err := jsonString(path+".type", fam.Type)
+	if err != nil && fam.Type != nil {
+		return nil, err
+	}
+	typ, ok := jsonTypes[strings.ToLower(typeName)]
+	if !ok {
+		return nil, &JSONError{Path: path + ".type", Msg: fmt.Sprintf("unknown metric type %q", typeName)}
+	}
+
+	mf := &dto.MetricFamily{Name: &name, Type: &typ}
+	if fam.Help != nil {
+		help, err := jsonString(path+".help", fam.Help)
+		if err != nil {
+			return nil, err
+		}
+		mf.Help = &help
+	}
+	if fam.Unit != nil {
+		unit, err := jsonString(path+".unit", fam.Unit)
+		if err != nil {
+			return nil, err
+		}
+		if unit != "" {
+			mf.Unit = &unit
+		}
+	}
+
+	for i, rawSample := range fam.Samples {
+		samplePath := fmt.Sprintf("%s.samples[%d]", path, i)
+		m, err := parseJSONSample(samplePath, typ, rawSample)
+		if err != nil {
+			return nil, err
+		}
+		mf.Metric = append(mf.Metric, m)
+	}
+
+	return mf, nil
+}
+
+func parseJSONSample(path string, typ dto.MetricType, raw json.RawMessage) (*dto.Metric, error) {
+	var sample jsonSample
+	if err := decodeJSON(bytes.NewReader(raw), path, &sample); err != nil {
+		return nil, err
+	}
+
+	m := &dto.Metric{}
+	for name, rawValue := range sample.Labels {
+		labelPath := path + ".labels." + name
+		if !model.ValidationScheme.IsValidLabelName(model.UTF8Validation, name) || strings.HasPrefix(name, "__") {
+			return nil, &JSONError{Path: labelPath, Msg: fmt.Sprintf("invalid label name %q", name)}
+		}
+
+		value, err := jsonLabelValue(labelPath, rawValue)
+		if err != nil {
+			return nil, err
+		}
+
+		m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+	}
+
+	if sample.TimestampMs != nil {
+		ts, err := jsonNumber(path+".timestamp_ms", sample.TimestampMs)
+		if err != nil {
+			return nil, err
+		}
+		tsMs := int64(ts)
+		m.TimestampMs = &tsMs
+	}
+
+	switch typ {
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		h, err := parseJSONHistogram(path, &sample)
+		if err != nil {
+			return nil, err
+		}
+		m.Histogram = h
+
+	case dto.MetricType_SUMMARY:
+		s, err := parseJSONSummary(path, &sample)
+		if err != nil {
+			return nil, err
+		}
+		m.Summary = s
+
+	default:
+		value, err := jsonNumber(path+".value", sample.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		switch typ {
+		case dto.MetricType_COUNTER:
+			if value < 0 {
+				return nil, &JSONError{Path: path + ".value", Msg: "counter value must not be negative"}
+			}
+			m.Counter = &dto.Counter{Value: &value}
+		case dto.MetricType_GAUGE:
+			m.Gauge = &dto.Gauge{Value: &value}
+		default:
+			m.Untyped = &dto.Untyped{Value: &value}
+		}
+	}
+
+	return m, nil
+}
+
+func parseJSONHistogram(path string, sample *jsonSample) (*dto.Histogram, error) {
+	count, sum, err := jsonCountSum(path, sample)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := make([]float64, 0, len(sample.Buckets))
+	counts := make(map[float64]uint64, len(sample.Buckets))
+	for key, rawCount := range sample.Buckets {
+		bucketPath := path + ".buckets." + key
+		bound, err := strconv.ParseFloat(key, 64)
+		if err != nil || math.IsNaN(bound) {
+			return nil, &JSONError{Path: bucketPath, Msg: fmt.Sprintf("invalid bucket upper bound %q", key)}
+		}
+
+		bucketCount, err := jsonCount(bucketPath, rawCount)
+		if err != nil {
+			return nil, err
+		}
+
+		bounds = append(bounds, bound)
+		counts[bound] = bucketCount
+	}
+	sort.Float64s(bounds)
+
+	h := &dto.Histogram{SampleCount: &count, SampleSum: &sum}
+	var previous uint64
+	for _, bound := range bounds {
+		cumulative := counts[bound]
+		if cumulative < previous || cumulative > count {
+			return nil, &JSONError{
+				Path: path + ".buckets",
+				Msg:  "bucket counts must be cumulative and not exceed count",
+			}
+		}
+		previous = cumulative
+
+		h.Bucket = append(h.Bucket, &dto.Bucket{UpperBound: &bound, CumulativeCount: &cumulative})
+	}
+
+	return h, nil
+}
+
+func parseJSONSummary(path string, sample *jsonSample) (*dto.Summary, error) {
+	count, sum, err := jsonCountSum(path, sample)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &dto.Summary{SampleCount: &count, SampleSum: &sum}
+	for key, rawValue := range sample.Quantiles {
+		quantilePath := path + ".quantiles." + key
+		quantile, err := strconv.ParseFloat(key, 64)
+		if err != nil || quantile < 0 || quantile > 1 {
+			return nil, &JSONError{Path: quantilePath, Msg: fmt.Sprintf("invalid quantile %q", key)}
+		}
+
+		value, err := jsonNumber(quantilePath, rawValue)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Quantile = append(s.Quantile, &dto.Quantile{Quantile: &quantile, Value: &value})
+	}
+
+	sort.Slice(s.Quantile, func(i, j int) bool {
+		return s.Quantile[i].GetQuantile() < s.Quantile[j].GetQuantile()
+	})
+
+	return s, nil
+}
+
+// jsonCountSum reads "count" and "sum" of histogram and summary samples.
+func jsonCountSum(path string, sample *jsonSample) (uint64, float64, error) {
+	count, err := jsonCount(path+".count", sample.Count)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sum, err := jsonNumber(path+".sum", sample.Sum)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return count, sum, nil
+}
+
+// decodeJSON decodes a JSON object, unknown fields are errors so typos are not silently ignored.
+func decodeJSON(input io.Reader, path string, v any) error {
+	dec := json.NewDecoder(input)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return &JSONError{Path: joinJSONPath(path, typeErr.Field), Msg: "unexpected JSON " + typeErr.Value}
+		}
+
+		return &JSONError{Path: path, Msg: err.Error()}
+	}
+
+	return nil
+}
+
+// jsonString reads a required string value.
+func jsonString(path string, raw json.RawMessage) (string, error) {
+	var s string
+	if raw == nil {
+		return "", &JSONError{Path: path, Msg: "value is required"}
+	}
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", &JSONError{Path: path, Msg: "expected string"}
+	}
+
+	return s, nil
+}
+
+// jsonNumber reads a required number, strings are parsed ("NaN", "+Inf", "1.5") and booleans are 1 or 0.
+func jsonNumber(path string, raw json.RawMessage) (float64, error) {
+	if raw == nil {
+		return 0, &JSONError{Path: path, Msg: "value is required"}
+	}
+
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return 0, &JSONError{Path: path, Msg: err.Error()}
+	}
+
+	switch value := v.(type) {
+	case float64:
+		return value, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return 0, &JSONError{Path: path, Msg: fmt.Sprintf("invalid number %q", value)}
+		}
+		return f, nil
+	case bool:
+		if value {
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		return 0, &JSONError{Path: path, Msg: "expected number, got " + jsonKindOf(v)}
+	}
+}
+
+// jsonCount reads a required non-negative integer.
+func jsonCount(path string, raw json.RawMessage) (uint64, error) {
+	f, err := jsonNumber(path, raw)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 || f != math.Trunc(f) || f > math.MaxUint64 {
+		return 0, &JSONError{Path: path, Msg: "expected non-negative integer"}
+	}
+
+	return uint64(f), nil
+}
+
+// jsonLabelValue reads a label value, numbers and booleans are converted to their JSON text.
+func jsonLabelValue(path string, raw json.RawMessage) (string, error) {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", &JSONError{Path: path, Msg: err.Error()}
+	}
+
+	switch value := v.(type) {
+	case string:
+		return value, nil
+	case float64, bool:
+		return string(bytes.TrimSpace(raw)), nil
+	default:
+		return "", &JSONError{Path: path, Msg: "expected string, got " + jsonKindOf(v)}
+	}
+}
+
+// jsonKindOf names the JSON type of a decoded value.
+func jsonKindOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	default:
+		return "number"
+	}
+}
+
+// joinJSONPath appends a dotted field path to path.
+func joinJSONPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+
+	return path + "." + field
+}
@@ -0,0 +1,754 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxLineSize bounds a single exposition line, the whole body is bounded by ingest limits.
+const maxLineSize = 4 << 20
+
+// SyntaxError describes a malformed payload line.
+type SyntaxError struct {
+	Msg    string
+	Line   int
+	Column int
+}
+
+// Error implements error.
+func (e *SyntaxError) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+	}
+
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// OpenMetrics family types mapped to client model types.
+var openMetricsTypes = map[string]dto.MetricType{
+	"counter":        dto.MetricType_COUNTER,
+	"gauge":          dto.MetricType_GAUGE,
+	"histogram":      dto.MetricType_HISTOGRAM,
+	"gaugehistogram": dto.MetricType_GAUGE_HISTOGRAM,
+	"summary":        dto.MetricType_SUMMARY,
+	"info":           dto.MetricType_GAUGE,
+	"stateset":       dto.MetricType_GAUGE,
+	"unknown":        dto.MetricType_UNTYPED,
+}
+
+// Allowed sample name suffixes per OpenMetrics family type.
+var openMetricsSuffixes = map[string][]string{
+	"counter":        {"_total", "_created"},
+	"gauge":          {""},
+	"histogram":      {"_bucket", "_count", "_sum", "_created"},
+	"gaugehistogram": {"_bucket", "_gcount", "_gsum"},
+	"summary":        {"", "_count", "_sum", "_created"},
+	"info":           {"_info"},
+	"stateset":       {""},
+	"unknown":        {""},
+}
+
+// omFamily accumulates samples of one OpenMetrics metric family.
+type omFamily struct {
+	mf     *dto.MetricFamily
+	series map[string]*dto.Metric
+	name   string
+	typ    string
+}
+
+// omSample is a single parsed sample line.
+type omSample struct {
+	exemplar  *dto.Exemplar
+	timestamp *float64
+	name      string
+	labels    []*dto.LabelPair
+	value     float64
+}
+
+// openMetricsDecoder parses the whole OpenMetrics payload on first use and yields families.
+type openMetricsDecoder struct {
+	input    io.Reader
+	err      error
+	families []*dto.MetricFamily
+	parsed   bool
+}
+
+func newOpenMetricsDecoder(input io.Reader) *openMetricsDecoder {
+	return &openMetricsDecoder{input: input}
+}
+
+func (d *openMetricsDecoder) next() (*dto.MetricFamily, error) {
+	if !d.parsed {
+		d.families, d.err = parseOpenMetrics(d.input)
+		d.parsed = true
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.families) == 0 {
+		return nil, io.EOF
+	}
+
+	mf := d.families[0]
+	d.families = d.families[1:]
+
+	return mf, nil
+}
+
+// parseOpenMetrics converts OpenMetrics text into client model families.
+// Counter families are named with the "_total" suffix and info families with the "_info" suffix,
+// so they are exposed exactly as the same payload in Prometheus text format would be.
+// "_created" samples, exemplars and "# UNIT" metadata are preserved.
+func parseOpenMetrics(input io.Reader) ([]*dto.MetricFamily, error) {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	var (
+		result  []*dto.MetricFamily
+		current *omFamily
+		lineNo  int
+		eof     bool
+	)
+	seen := make(map[string]bool)
+
+	// A family ends with the first line of the next one or with # EOF, incomplete series are rejected there
+	endFamily := func() error {
+		if current == nil {
+			return nil
+		}
+		if err := current.validate(); err != nil {
+			return &SyntaxError{Line: lineNo, Msg: err.Error()}
+		}
+
+		return nil
+	}
+
+	startFamily := func(name, typ string) (*omFamily, error) {
+		if seen[name] {
+			return nil, fmt.Errorf("metric family %q is not contiguous or declared twice", name)
+		}
+		seen[name] = true
+
+		fam := &omFamily{
+			name:   name,
+			typ:    typ,
+			series: make(map[string]*dto.Metric),
+			mf:     &dto.MetricFamily{},
+		}
+		fam.setType(typ)
+		result = append(result, fam.mf)
+
+		return fam, nil
+	}
+
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+
+		if eof {
+			return nil, &SyntaxError{Line: lineNo, Msg: "unexpected content after # EOF"}
+		}
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			if line == "# EOF" {
+				if err := endFamily(); err != nil {
+					return nil, err
+				}
+				eof = true
+				continue
+			}
+
+			parts := strings.SplitN(line, " ", 4)
+			if len(parts) < 3 || parts[0] != "#" {
+				// Plain comments are not part of OpenMetrics, tolerate them like the text format does
+				continue
+			}
+
+			keyword, name := parts[1], parts[2]
+			var text string
+			if len(parts) == 4 {
+				text = parts[3]
+			}
+
+			switch keyword {
+			case "TYPE", "HELP", "UNIT":
+			default:
+				continue
+			}
+
+			if current == nil || current.name != name {
+				if err := endFamily(); err != nil {
+					return nil, err
+				}
+				fam, err := startFamily(name, "unknown")
+				if err != nil {
+					return nil, &SyntaxError{Line: lineNo, Column: 8, Msg: err.Error()}
+				}
+				current = fam
+			}
+
+			switch keyword {
+			case "TYPE":
+				if _, ok := openMetricsTypes[text]; !ok {
+					return nil, &SyntaxError{Line: lineNo, Column: len(name) + 9, Msg: fmt.Sprintf("unknown metric type %q", text)}
+				}
+				if len(current.series) > 0 {
+					return nil, &SyntaxError{Line: lineNo, Msg: fmt.Sprintf("TYPE for %q must precede its samples", name)}
+				}
+				current.typ = text
+				current.setType(text)
+
+			case "HELP":
+				help := unescapeOpenMetrics(text)
+				current.mf.Help = &help
+
+			case "UNIT":
+				if text != "" {
+					unit := text
+					current.mf.Unit = &unit
+				}
+			}
+
+			continue
+		}
+
+		sample, err := parseSampleLine(line)
+		if err != nil {
+			var synErr *SyntaxError
+			if errors.As(err, &synErr) {
+				synErr.Line = lineNo
+				return nil, synErr
+			}
+			return nil, &SyntaxError{Line: lineNo, Msg: err.Error()}
+		}
+
+		suffix, ok := "", false
+		if current != nil {
+			suffix, ok = current.matchSuffix(sample.name)
+		}
+		if !ok {
+			if err := endFamily(); err != nil {
+				return nil, err
+			}
+			fam, err := startFamily(sample.name, "unknown")
+			if err != nil {
+				return nil, &SyntaxError{Line: lineNo, Column: 1, Msg: err.Error()}
+			}
+			current = fam
+		}
+
+		if err := current.addSample(suffix, sample); err != nil {
+			return nil, &SyntaxError{Line: lineNo, Msg: err.Error()}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading openmetrics payload: %w", err)
+	}
+
+	// Without the terminator a truncated payload is indistinguishable from a complete one
+	if !eof {
+		return nil, &SyntaxError{Line: lineNo + 1, Msg: "missing # EOF at the end of payload"}
+	}
+
+	return result, nil
+}
+
+// setType applies the OpenMetrics type and derived exposition name to the family.
+func (f *omFamily) setType(typ string) {
+	name := f.name
+	switch {
+	case typ == "counter" && !strings.HasSuffix(name, "_total"):
+		name += "_total"
+	case typ == "info":
+		name += "_info"
+	}
+
+	f.mf.Name = &name
+	f.mf.Type = openMetricsTypes[typ].Enum()
+}
+
+// matchSuffix checks whether the sample belongs to the family and returns its suffix.
+func (f *omFamily) matchSuffix(sampleName string) (string, bool) {
+	if !strings.HasPrefix(sampleName, f.name) {
+		return "", false
+	}
+
+	suffix := sampleName[len(f.name):]
+
+	// Tolerate counters declared with the "_total" suffix in TYPE, as Prometheus text does
+	if f.typ == "counter" && suffix == "" && strings.HasSuffix(f.name, "_total") {
+		return "_total", true
+	}
+
+	for _, allowed := range openMetricsSuffixes[f.typ] {
+		if suffix == allowed {
+			return suffix, true
+		}
+	}
+
+	return "", false
+}
+
+// addSample merges a sample into the series it belongs to.
+func (f *omFamily) addSample(suffix string, sample *omSample) error {
+	isHistogram := f.typ == "histogram" || f.typ == "gaugehistogram"
+
+	var (
+		seriesLabels []*dto.LabelPair
+		le, quantile string
+		hasLe        bool
+		hasQuantile  bool
+	)
+	for _, lp := range sample.labels {
+		switch {
+		case isHistogram && suffix == "_bucket" && lp.GetName() == "le":
+			le, hasLe = lp.GetValue(), true
+		case f.typ == "summary" && suffix == "" && lp.GetName() == "quantile":
+			quantile, hasQuantile = lp.GetValue(), true
+		default:
+			seriesLabels = append(seriesLabels, lp)
+		}
+	}
+
+	key := seriesKey(seriesLabels)
+	m, ok := f.series[key]
+	if !ok {
+		m = &dto.Metric{Label: seriesLabels}
+		f.series[key] = m
+		f.mf.Metric = append(f.mf.Metric, m)
+	}
+
+	if sample.timestamp != nil {
+		ts := int64(*sample.timestamp * 1000)
+		m.TimestampMs = &ts
+	}
+
+	value := sample.value
+
+	switch f.typ {
+	case "counter":
+		if m.Counter == nil {
+			m.Counter = &dto.Counter{}
+		}
+		if suffix == "_created" {
+			m.Counter.CreatedTimestamp = secondsToTimestamp(value)
+			return nil
+		}
+		m.Counter.Value = &value
+		if sample.exemplar != nil {
+			m.Counter.Exemplar = sample.exemplar
+		}
+
+	case "gauge", "info", "stateset":
+		m.Gauge = &dto.Gauge{Value: &value}
+
+	case "unknown":
+		m.Untyped = &dto.Untyped{Value: &value}
+
+	case "histogram", "gaugehistogram":
+		if m.Histogram == nil {
+			m.Histogram = &dto.Histogram{}
+		}
+		switch suffix {
+		case "_bucket":
+			if !hasLe {
+				return fmt.Errorf("bucket of %q has no le label", f.name)
+			}
+			upper, err := strconv.ParseFloat(le, 64)
+			if err != nil {
+				return fmt.Errorf("invalid le label %q: %w", le, err)
+			}
+			count, err := sampleCount(f.name+suffix, value)
+			if err != nil {
+				return err
+			}
+			m.Histogram.Bucket = append(m.Histogram.Bucket, &dto.Bucket{
+				UpperBound:      &upper,
+				CumulativeCount: &count,
+				Exemplar:        sample.exemplar,
+			})
+		case "_count", "_gcount":
+			count, err := sampleCount(f.name+suffix, value)
+			if err != nil {
+				return err
+			}
+			m.Histogram.SampleCount = &count
+		case "_sum", "_gsum":
+			m.Histogram.SampleSum = &value
+		case "_created":
+			m.Histogram.CreatedTimestamp = secondsToTimestamp(value)
+		}
+
+	case "summary":
+		if m.Summary == nil {
+			m.Summary = &dto.Summary{}
+		}
+		switch suffix {
+		case "":
+			if !hasQuantile {
+				return fmt.Errorf("summary sample of %q has no quantile label", f.name)
+			}
+			q, err := strconv.ParseFloat(quantile, 64)
+			if err != nil {
+				return fmt.Errorf("invalid quantile label %q: %w", quantile, err)
+			}
+			m.Summary.Quantile = append(m.Summary.Quantile, &dto.Quantile{Quantile: &q, Value: &value})
+		case "_count":
+			count, err := sampleCount(f.name+suffix, value)
+			if err != nil {
+				return err
+			}
+			m.Summary.SampleCount = &count
+		case "_sum":
+			m.Summary.SampleSum = &value
+		case "_created":
+			m.Summary.CreatedTimestamp = secondsToTimestamp(value)
+		}
+	}
+
+	return nil
+}
+
+// validate checks that every series of the family has the samples its exposition requires:
+// "_total" of counters, "+Inf" bucket, count and sum of histograms, count and sum of summaries.
+func (f *omFamily) validate() error {
+	countSuffix, sumSuffix := "_count", "_sum"
+	if f.typ == "gaugehistogram" {
+		countSuffix, sumSuffix = "_gcount", "_gsum"
+	}
+
+	for _, m := range f.mf.Metric {
+		switch f.typ {
+		case "counter":
+			if m.Counter.Value == nil {
+				return fmt.Errorf("counter %q series %s has no _total sample", f.name, labelsText(m.Label))
+			}
+
+		case "histogram", "gaugehistogram":
+			h := m.Histogram
+			if h.SampleCount == nil || h.SampleSum == nil {
+				return fmt.Errorf("%s %q series %s must have %s and %s samples", f.typ, f.name, labelsText(m.Label), countSuffix, sumSuffix)
+			}
+
+			var inf *dto.Bucket
+			for _, b := range h.Bucket {
+				if math.IsInf(b.GetUpperBound(), 1) {
+					inf = b
+				}
+			}
+			if inf == nil {
+				return fmt.Errorf("%s %q series %s has no +Inf bucket", f.typ, f.name, labelsText(m.Label))
+			}
+			if inf.GetCumulativeCount() != h.GetSampleCount() {
+				return fmt.Errorf("%s %q series %s: +Inf bucket %d does not match %s %d",
+					f.typ, f.name, labelsText(m.Label), inf.GetCumulativeCount(), countSuffix, h.GetSampleCount())
+			}
+
+		case "summary":
+			if m.Summary.SampleCount == nil || m.Summary.SampleSum == nil {
+				return fmt.Errorf("summary %q series %s must have _count and _sum samples", f.name, labelsText(m.Label))
+			}
+		}
+	}
+
+	return nil
+}
+
+// labelsText formats labels of a series for error messages.
+func labelsText(labels []*dto.LabelPair) string {
+	pairs := make([]string, 0, len(labels))
+	for _, lp := range labels {
+		pairs = append(pairs, lp.GetName()+"="+strconv.Quote(lp.GetValue()))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sampleCount converts a bucket or count sample value, it must be a non-negative integer.
+func sampleCount(name string, value float64) (uint64, error) {
+	if value < 0 || value != math.Trunc(value) || value >= math.MaxUint64 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, got %v", name, value)
+	}
+
+	return uint64(value), nil
+}
+
+// parseSampleLine parses `name{labels} value [timestamp] [# {labels} value [timestamp]]`.
+// Returned SyntaxError carries the column only, the caller fills in the line.
+func parseSampleLine(line string) (*omSample, error) {
+	l := &lineLexer{line: line}
+	sample := &omSample{}
+
+	name := l.readName()
+	if name == "" {
+		return nil, l.errorf("expected metric name")
+	}
+	sample.name = name
+
+	if l.peek() == '{' {
+		labels, err := l.readLabels()
+		if err != nil {
+			return nil, err
+		}
+		sample.labels = labels
+	}
+
+	if !l.skip(' ') {
+		return nil, l.errorf("expected space before value")
+	}
+
+	value, err := l.readFloat()
+	if err != nil {
+		return nil, err
+	}
+	sample.value = value
+
+	if l.done() {
+		return sample, nil
+	}
+	if !l.skip(' ') {
+		return nil, l.errorf("unexpected character %q after value", l.peek())
+	}
+
+	if l.peek() != '#' {
+		ts, err := l.readFloat()
+		if err != nil {
+			return nil, err
+		}
+		sample.timestamp = &ts
+
+		if l.done() {
+			return sample, nil
+		}
+		if !l.skip(' ') {
+			return nil, l.errorf("unexpected character %q after timestamp", l.peek())
+		}
+	}
+
+	if !l.skip('#') || !l.skip(' ') {
+		return nil, l.errorf("expected exemplar")
+	}
+
+	exemplar, err := l.readExemplar()
+	if err != nil {
+		return nil, err
+	}
+	sample.exemplar = exemplar
+
+	return sample, nil
+}
+
+// lineLexer is a minimal cursor over a single exposition line.
+type lineLexer struct {
+	line string
+	pos  int
+}
+
+func (l *lineLexer) done() bool {
+	return l.pos >= len(l.line)
+}
+
+func (l *lineLexer) peek() byte {
+	if l.done() {
+		return 0
+	}
+
+	return l.line[l.pos]
+}
+
+func (l *lineLexer) skip(c byte) bool {
+	if l.peek() == c && !l.done() {
+		l.pos++
+		return true
+	}
+
+	return false
+}
+
+func (l *lineLexer) errorf(format string, args ...any) error {
+	return &SyntaxError{Column: l.pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+// readName reads a metric or label name.
+func (l *lineLexer) readName() string {
+	start := l.pos
+	for !l.done() {
+		c := l.line[l.pos]
+		isAlpha := c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		isDigit := c >= '0' && c <= '9'
+		if !isAlpha && (!isDigit || l.pos == start) {
+			break
+		}
+		l.pos++
+	}
+
+	return l.line[start:l.pos]
+}
+
+// readLabels reads `{name="value",...}`.
+func (l *lineLexer) readLabels() ([]*dto.LabelPair, error) {
+	if !l.skip('{') {
+		return nil, l.errorf("expected '{'")
+	}
+
+	var labels []*dto.LabelPair
+	for !l.skip('}') {
+		if l.done() {
+			return nil, l.errorf("unterminated label set")
+		}
+
+		name := l.readName()
+		if name == "" {
+			return nil, l.errorf("expected label name")
+		}
+		if !l.skip('=') {
+			return nil, l.errorf("expected '=' after label name %q", name)
+		}
+
+		value, err := l.readQuoted()
+		if err != nil {
+			return nil, err
+		}
+
+		labels = append(labels, &dto.LabelPair{Name: &name, Value: &value})
+
+		if !l.skip(',') && l.peek() != '}' {
+			return nil, l.errorf("expected ',' or '}' after label value")
+		}
+	}
+
+	return labels, nil
+}
+
+// readQuoted reads a double-quoted, escaped label value.
+func (l *lineLexer) readQuoted() (string, error) {
+	if !l.skip('"') {
+		return "", l.errorf("expected '\"' to open label value")
+	}
+
+	var b strings.Builder
+	for {
+		if l.done() {
+			return "", l.errorf("unterminated label value")
+		}
+
+		c := l.line[l.pos]
+		l.pos++
+
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if l.done() {
+				return "", l.errorf("unterminated escape sequence")
+			}
+			esc := l.line[l.pos]
+			l.pos++
+			switch esc {
+			case '\\', '"':
+				b.WriteByte(esc)
+			case 'n':
+				b.WriteByte('\n')
+			default:
+				l.pos -= 2
+				return "", l.errorf("invalid escape sequence '\\%c'", esc)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// readFloat reads a number token up to the next space.
+func (l *lineLexer) readFloat() (float64, error) {
+	start := l.pos
+	for !l.done() && l.line[l.pos] != ' ' {
+		l.pos++
+	}
+
+	token := l.line[start:l.pos]
+	if token == "" {
+		l.pos = start
+		return 0, l.errorf("expected number")
+	}
+
+	v, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		l.pos = start
+		return 0, l.errorf("invalid number %q", token)
+	}
+
+	return v, nil
+}
+
+// readExemplar reads `{labels} value [timestamp]`.
+func (l *lineLexer) readExemplar() (*dto.Exemplar, error) {
+	labels, err := l.readLabels()
+	if err != nil {
+		return nil, err
+	}
+	if !l.skip(' ') {
+		return nil, l.errorf("expected space before exemplar value")
+	}
+
+	value, err := l.readFloat()
+	if err != nil {
+		return nil, err
+	}
+
+	exemplar := &dto.Exemplar{Label: labels, Value: &value}
+
+	if l.skip(' ') {
+		ts, err := l.readFloat()
+		if err != nil {
+			return nil, err
+		}
+		exemplar.Timestamp = secondsToTimestamp(ts)
+	}
+
+	if !l.done() {
+		return nil, l.errorf("unexpected character %q after exemplar", l.peek())
+	}
+
+	return exemplar, nil
+}
+
+// seriesKey builds an order-independent identity of a label set.
+func seriesKey(labels []*dto.LabelPair) string {
+	pairs := make([]string, 0, len(labels))
+	for _, lp := range labels {
+		pairs = append(pairs, lp.GetName()+"\xff"+lp.GetValue())
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "\xfe")
+}
+
+// secondsToTimestamp converts float unix seconds to a protobuf timestamp.
+func secondsToTimestamp(v float64) *timestamppb.Timestamp {
+	sec, frac := math.Modf(v)
+
+	return &timestamppb.Timestamp{Seconds: int64(sec), Nanos: int32(frac * 1e9)}
+}
+
+// unescapeOpenMetrics resolves escapes allowed in HELP text.
+func unescapeOpenMetrics(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	return strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\"`, `"`).Replace(s)
+}
@@ -0,0 +1,340 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseOpenMetrics(t *testing.T) {
+	tests := []struct {
+		check func(t *testing.T, families map[string]*dto.MetricFamily)
+		name  string
+		input string
+	}{
+		{
+			name: "counter with created and exemplar",
+			input: `# TYPE dayz_kills counter
+# HELP dayz_kills Total kills.
+dayz_kills_total{map="chernarus"} 17 # {trace_id="abc"} 1 1700000000.5
+dayz_kills_created{map="chernarus"} 1699999000.25
+# EOF
+`,
+			check: func(t *testing.T, families map[string]*dto.MetricFamily) {
+				mf := mustFamily(t, families, "dayz_kills_total", dto.MetricType_COUNTER)
+				if mf.GetHelp() != "Total kills." {
+					t.Errorf("help = %q", mf.GetHelp())
+				}
+
+				c := mustSingle(t, mf).GetCounter()
+				if c.GetValue() != 17 {
+					t.Errorf("value = %v, want 17", c.GetValue())
+				}
+				if got := c.GetCreatedTimestamp().AsTime().UnixMilli(); got != 1699999000250 {
+					t.Errorf("created = %d ms", got)
+				}
+				if ex := c.GetExemplar(); ex.GetValue() != 1 || len(ex.GetLabel()) != 1 || ex.GetLabel()[0].GetValue() != "abc" {
+					t.Errorf("exemplar = %v", ex)
+				}
+			},
+		},
+		{
+			name: "counter declared with total suffix",
+			input: `# TYPE dayz_deaths_total counter
+dayz_deaths_total 3
+# EOF
+`,
+			check: func(t *testing.T, families map[string]*dto.MetricFamily) {
+				mf := mustFamily(t, families, "dayz_deaths_total", dto.MetricType_COUNTER)
+				if v := mustSingle(t, mf).GetCounter().GetValue(); v != 3 {
+					t.Errorf("value = %v, want 3", v)
+				}
+			},
+		},
+		{
+			name: "histogram",
+			input: `# TYPE dayz_tick_seconds histogram
+# UNIT dayz_tick_seconds seconds
+dayz_tick_seconds_bucket{le="0.1"} 3 # {trace_id="t1"} 0.05
+dayz_tick_seconds_bucket{le="0.5"} 8
+dayz_tick_seconds_bucket{le="+Inf"} 10
+dayz_tick_seconds_count 10
+dayz_tick_seconds_sum 2.5
+dayz_tick_seconds_created 1700000000
+# EOF
+`,
+			check: func(t *testing.T, families map[string]*dto.MetricFamily) {
+				mf := mustFamily(t, families, "dayz_tick_seconds", dto.MetricType_HISTOGRAM)
+				if mf.GetUnit() != "seconds" {
+					t.Errorf("unit = %q", mf.GetUnit())
+				}
+
+				h := mustSingle(t, mf).GetHistogram()
+				if h.GetSampleCount() != 10 || h.GetSampleSum() != 2.5 {
+					t.Errorf("count = %d, sum = %v", h.GetSampleCount(), h.GetSampleSum())
+				}
+				if len(h.GetBucket()) != 3 {
+					t.Fatalf("buckets = %d, want 3", len(h.GetBucket()))
+				}
+				if b := h.GetBucket()[1]; b.GetUpperBound() != 0.5 || b.GetCumulativeCount() != 8 {
+					t.Errorf("bucket = %v", b)
+				}
+				if h.GetBucket()[0].GetExemplar().GetValue() != 0.05 {
+					t.Errorf("bucket exemplar = %v", h.GetBucket()[0].GetExemplar())
+				}
+				if h.GetCreatedTimestamp().GetSeconds() != 1700000000 {
+					t.Errorf("created = %v", h.GetCreatedTimestamp())
+				}
+			},
+		},
+		{
+			name: "gauge histogram",
+			input: `# TYPE dayz_queue gaugehistogram
+dayz_queue_bucket{le="1"} 2
+dayz_queue_bucket{le="+Inf"} 5
+dayz_queue_gcount 5
+dayz_queue_gsum 7
+# EOF
+`,
+			check: func(t *testing.T, families map[string]*dto.MetricFamily) {
+				mf := mustFamily(t, families, "dayz_queue", dto.MetricType_GAUGE_HISTOGRAM)
+				h := mustSingle(t, mf).GetHistogram()
+				if h.GetSampleCount() != 5 || h.GetSampleSum() != 7 || len(h.GetBucket()) != 2 {
+					t.Errorf("histogram = %v", h)
+				}
+			},
+		},
+		{
+			name: "summary",
+			input: `# TYPE dayz_rpc_seconds summary
+dayz_rpc_seconds{quantile="0.5",rpc="a"} 0.01
+dayz_rpc_seconds{quantile="0.99",rpc="a"} 0.2
+dayz_rpc_seconds_count{rpc="a"} 42
+dayz_rpc_seconds_sum{rpc="a"} 1.5
+# EOF
+`,
+			check: func(t *testing.T, families map[string]*dto.MetricFamily) {
+				mf := mustFamily(t, families, "dayz_rpc_seconds", dto.MetricType_SUMMARY)
+				s := mustSingle(t, mf).GetSummary()
+				if s.GetSampleCount() != 42 || s.GetSampleSum() != 1.5 || len(s.GetQuantile()) != 2 {
+					t.Errorf("summary = %v", s)
+				}
+				if q := s.GetQuantile()[1]; q.GetQuantile() != 0.99 || q.GetValue() != 0.2 {
+					t.Errorf("quantile = %v", q)
+				}
+			},
+		},
+		{
+			name: "gauge and unknown with timestamp",
+			input: `# TYPE dayz_players gauge
+dayz_players 42 1700000000.123
+dayz_untyped_thing 1
+# EOF
+`,
+			check: func(t *testing.T, families map[string]*dto.MetricFamily) {
+				m := mustSingle(t, mustFamily(t, families, "dayz_players", dto.MetricType_GAUGE))
+				if m.GetGauge().GetValue() != 42 || m.GetTimestampMs() != 1700000000123 {
+					t.Errorf("gauge = %v", m)
+				}
+				mustFamily(t, families, "dayz_untyped_thing", dto.MetricType_UNTYPED)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseOpenMetrics(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			families := make(map[string]*dto.MetricFamily, len(result))
+			for _, mf := range result {
+				families[mf.GetName()] = mf
+			}
+			tt.check(t, families)
+		})
+	}
+}
+
+func TestParseOpenMetricsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		line  int
+	}{
+		{
+			name:  "missing EOF",
+			input: "# TYPE a gauge\na 1\n",
+			want:  "missing # EOF",
+			line:  3,
+		},
+		{
+			name:  "content after EOF",
+			input: "a 1\n# EOF\na 2\n",
+			want:  "after # EOF",
+			line:  3,
+		},
+		{
+			name:  "negative bucket",
+			input: "# TYPE h histogram\nh_bucket{le=\"+Inf\"} -1\n# EOF\n",
+			want:  "non-negative integer",
+			line:  2,
+		},
+		{
+			name:  "fractional histogram count",
+			input: "# TYPE h histogram\nh_count 1.5\n# EOF\n",
+			want:  "non-negative integer",
+			line:  2,
+		},
+		{
+			name:  "negative summary count",
+			input: "# TYPE s summary\ns_count -3\n# EOF\n",
+			want:  "non-negative integer",
+			line:  2,
+		},
+		{
+			name:  "NaN gauge histogram count",
+			input: "# TYPE g gaugehistogram\ng_gcount NaN\n# EOF\n",
+			want:  "non-negative integer",
+			line:  2,
+		},
+		{
+			name:  "counter with created only",
+			input: "# TYPE c counter\nc_created 1700000000\n# EOF\n",
+			want:  "has no _total sample",
+			line:  3,
+		},
+		{
+			name:  "histogram without count",
+			input: "# TYPE h histogram\nh_bucket{le=\"+Inf\"} 2\nh_sum 3\n# TYPE g gauge\ng 1\n# EOF\n",
+			want:  "must have _count and _sum samples",
+			line:  4,
+		},
+		{
+			name:  "histogram without inf bucket",
+			input: "# TYPE h histogram\nh_bucket{le=\"1\"} 2\nh_count 2\nh_sum 3\n# EOF\n",
+			want:  "has no +Inf bucket",
+			line:  5,
+		},
+		{
+			name:  "histogram inf bucket mismatch",
+			input: "# TYPE h histogram\nh_bucket{le=\"+Inf\"} 2\nh_count 3\nh_sum 3\n# EOF\n",
+			want:  "does not match _count",
+			line:  5,
+		},
+		{
+			name:  "gauge histogram without gsum",
+			input: "# TYPE g gaugehistogram\ng_bucket{le=\"+Inf\"} 2\ng_gcount 2\nother 1\n# EOF\n",
+			want:  "must have _gcount and _gsum samples",
+			line:  4,
+		},
+		{
+			name:  "summary without sum",
+			input: "# TYPE s summary\ns{quantile=\"0.5\",a=\"b\"} 1\ns_count{a=\"b\"} 4\n# EOF\n",
+			want:  `series {a="b"} must have _count and _sum samples`,
+			line:  4,
+		},
+		{
+			name:  "bucket without le",
+			input: "# TYPE h histogram\nh_bucket 1\n# EOF\n",
+			want:  "no le label",
+			line:  2,
+		},
+		{
+			name:  "summary without quantile",
+			input: "# TYPE s summary\ns 1\n# EOF\n",
+			want:  "no quantile label",
+			line:  2,
+		},
+		{
+			name:  "unknown type",
+			input: "# TYPE a widget\n# EOF\n",
+			want:  "unknown metric type",
+			line:  1,
+		},
+		{
+			name:  "type after samples",
+			input: "a 1\n# TYPE a gauge\n# EOF\n",
+			want:  "must precede its samples",
+			line:  2,
+		},
+		{
+			name:  "family not contiguous",
+			input: "a 1\nb 1\na 2\n# EOF\n",
+			want:  "not contiguous",
+			line:  3,
+		},
+		{
+			name:  "invalid escape",
+			input: "a{x=\"\\t\"} 1\n# EOF\n",
+			want:  "invalid escape sequence",
+			line:  1,
+		},
+		{
+			name:  "unterminated labels",
+			input: "a{x=\"1\" 1\n# EOF\n",
+			want:  "expected ',' or '}'",
+			line:  1,
+		},
+		{
+			name:  "invalid value",
+			input: "a one\n# EOF\n",
+			want:  "invalid number",
+			line:  1,
+		},
+		{
+			name:  "invalid exemplar",
+			input: "# TYPE c counter\nc_total 1 # trace 1\n# EOF\n",
+			want:  "expected '{'",
+			line:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseOpenMetrics(strings.NewReader(tt.input))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+
+			var synErr *SyntaxError
+			if !errors.As(err, &synErr) {
+				t.Fatalf("error %v is not *SyntaxError", err)
+			}
+			if !strings.Contains(synErr.Msg, tt.want) {
+				t.Errorf("error = %q, want %q", synErr.Msg, tt.want)
+			}
+			if synErr.Line != tt.line {
+				t.Errorf("line = %d, want %d", synErr.Line, tt.line)
+			}
+		})
+	}
+}
+
+func mustFamily(t *testing.T, families map[string]*dto.MetricFamily, name string, typ dto.MetricType) *dto.MetricFamily {
+	t.Helper()
+
+	mf, ok := families[name]
+	if !ok {
+		t.Fatalf("family %q not found", name)
+	}
+	if mf.GetType() != typ {
+		t.Fatalf("family %q type = %v, want %v", name, mf.GetType(), typ)
+	}
+
+	return mf
+}
+
+func mustSingle(t *testing.T, mf *dto.MetricFamily) *dto.Metric {
+	t.Helper()
+
+	if len(mf.GetMetric()) != 1 {
+		t.Fatalf("family %q has %d series, want 1", mf.GetName(), len(mf.GetMetric()))
+	}
+
+	return mf.GetMetric()[0]
+}
@@ -1,9 +1,11 @@
-// Package parser parses and validates Prometheus text exposition payloads.
+// Package parser parses and validates Prometheus text, OpenMetrics and protobuf exposition payloads.
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"sort"
 	"strconv"
 
@@ -13,21 +15,149 @@ import (
 	"github.com/woozymasta/dzid"
 )
 
-// ParseAndValidate parses Prometheus text format, injects/validates the instance_id,
+// Format identifies the wire format of an ingest payload.
+type Format int
+
+const (
+	// FormatText is the Prometheus text exposition format (default).
+	FormatText Format = iota
+
+	// FormatOpenMetrics is the OpenMetrics 1.0 text format.
+	FormatOpenMetrics
+
+	// FormatProtoDelim is a stream of length-delimited io.prometheus.client.MetricFamily protobuf messages.
+	FormatProtoDelim
+
+	// FormatJSON is the structured JSON format with families and samples as objects.
+	FormatJSON
+
+	// FormatInflux is InfluxDB line protocol, fields are mapped to untyped families.
+	FormatInflux
+)
+
+// String returns the format name used in logs.
+func (f Format) String() string {
+	switch f {
+	case FormatOpenMetrics:
+		return "openmetrics"
+	case FormatProtoDelim:
+		return "protobuf"
+	case FormatJSON:
+		return "json"
+	case FormatInflux:
+		return "influx"
+	default:
+		return "text"
+	}
+}
+
+// LineBased reports whether payload is newline separated text,
+// chunks of such payloads may be joined with a newline.
+func (f Format) LineBased() bool {
+	return f != FormatProtoDelim && f != FormatJSON
+}
+
+// StructuredJSONType is the media type selecting FormatJSON. Bare application/json keeps FormatText,
+// existing clients send text exposition with it.
+const StructuredJSONType = "application/vnd.metricz.families+json"
+
+// FormatFromContentType selects payload format by the Content-Type header value.
+// Empty or unknown content types fall back to FormatText.
+func FormatFromContentType(contentType string) Format {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return FormatText
+	}
+
+	switch mediaType {
+	case "application/openmetrics-text":
+		return FormatOpenMetrics
+
+	case StructuredJSONType:
+		return FormatJSON
+
+	case expfmt.ProtoType:
+		if p, ok := params["proto"]; ok && p != expfmt.ProtoProtocol {
+			return FormatText
+		}
+		if e, ok := params["encoding"]; ok && e != "delimited" {
+			return FormatText
+		}
+		return FormatProtoDelim
+
+	default:
+		return FormatText
+	}
+}
+
+// familyDecoder yields metric families one by one and returns io.EOF at the end of input.
+type familyDecoder interface {
+	next() (*dto.MetricFamily, error)
+}
+
+// expfmtDecoder adapts expfmt text and protobuf decoders to familyDecoder.
+type expfmtDecoder struct {
+	dec expfmt.Decoder
+}
+
+func (d *expfmtDecoder) next() (*dto.MetricFamily, error) {
+	// Use a pointer to avoid "copying lock value" errors in protobuf structs
+	mf := &dto.MetricFamily{}
+	if err := d.dec.Decode(mf); err != nil {
+		return nil, err
+	}
+
+	return mf, nil
+}
+
+// newFamilyDecoder creates decoder for the payload format.
+func newFamilyDecoder(input io.Reader, format Format) familyDecoder {
+	switch format {
+	case FormatOpenMetrics:
+		return newOpenMetricsDecoder(input)
+	case FormatJSON:
+		return newJSONDecoder(input)
+	case FormatInflux:
+		return newInfluxDecoder(input)
+	case FormatProtoDelim:
+		return &expfmtDecoder{dec: expfmt.NewDecoder(input, expfmt.NewFormat(expfmt.TypeProtoDelim))}
+	default:
+		return &expfmtDecoder{dec: expfmt.NewDecoder(input, expfmt.NewFormat(expfmt.TypeTextPlain))}
+	}
+}
+
+// ErrInstanceMismatch is returned when a series has an instance_id label other than the target instance.
+var ErrInstanceMismatch = errors.New("instance_id mismatch")
+
+// Stats describes a parsed payload.
+type Stats struct {
+	// Duplicates counts series collapsed by "Last Write Wins" by family name.
+	Duplicates map[string]int
+
+	// Series is the number of parsed series before deduplication.
+	Series int
+}
+
+// ParseAndValidate parses payload in the given format, injects/validates the instance_id,
 // and deduplicates metrics using "Last Write Wins" strategy.
-func ParseAndValidate(input io.Reader, targetInstanceID string, overwrite bool) (map[string]*dto.MetricFamily, error) {
-	decoder := expfmt.NewDecoder(input, expfmt.NewFormat(expfmt.TypeTextPlain))
+func ParseAndValidate(input io.Reader, format Format, targetInstanceID string, overwrite bool) (map[string]*dto.MetricFamily, error) {
+	families, _, err := ParseWithStats(input, format, targetInstanceID, overwrite)
+	return families, err
+}
+
+// ParseWithStats is ParseAndValidate that also reports payload statistics.
+func ParseWithStats(input io.Reader, format Format, targetInstanceID string, overwrite bool) (map[string]*dto.MetricFamily, *Stats, error) {
+	decoder := newFamilyDecoder(input, format)
 	families := make(map[string]*dto.MetricFamily)
+	stats := &Stats{Duplicates: make(map[string]int)}
 
 	for {
-		// Use a pointer to avoid "copying lock value" errors in protobuf structs
-		mf := &dto.MetricFamily{}
-		err := decoder.Decode(mf)
+		mf, err := decoder.next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("parsing failed: %w", err)
+			return nil, stats, fmt.Errorf("parsing failed: %w", err)
 		}
 
 		// Check if we need to calculate BUID for this family
@@ -75,9 +205,9 @@ func ParseAndValidate(input io.Reader, targetInstanceID string, overwrite bool)
 							val := targetInstanceID
 							labelPair.Value = &val
 						} else {
-							return nil, fmt.Errorf(
-								"instance_id mismatch in metric '%s': expected '%s', got '%s'",
-								mf.GetName(), targetInstanceID, currentVal,
+							return nil, stats, fmt.Errorf(
+								"%w in metric '%s': expected '%s', got '%s'",
+								ErrInstanceMismatch, mf.GetName(), targetInstanceID, currentVal,
 							)
 						}
 					}
@@ -102,27 +232,38 @@ func ParseAndValidate(input io.Reader, targetInstanceID string, overwrite bool)
 			})
 		}
 
-		// Deduplication (Last Write Wins)
-		// If the source sends duplicate metrics, only the last one is preserved.
-		uniqueMetrics := make(map[uint64]*dto.Metric)
-		for _, metric := range mf.Metric {
-			hash := getLabelHash(metric.Label)
-			uniqueMetrics[hash] = metric
+		stats.Series += len(mf.Metric)
+		if duplicates := Deduplicate(mf); duplicates > 0 {
+			stats.Duplicates[mf.GetName()] += duplicates
 		}
+		families[mf.GetName()] = mf
+	}
 
-		// Rebuild slice if duplicates were removed
-		if len(uniqueMetrics) != len(mf.Metric) {
-			cleanMetrics := make([]*dto.Metric, 0, len(uniqueMetrics))
-			for _, m := range uniqueMetrics {
-				cleanMetrics = append(cleanMetrics, m)
-			}
-			mf.Metric = cleanMetrics
-		}
+	return families, stats, nil
+}
 
-		families[mf.GetName()] = mf
+// Deduplicate removes series with equal label sets using "Last Write Wins" strategy
+// and returns the number of removed series.
+// Labels of every series must be sorted by name.
+func Deduplicate(mf *dto.MetricFamily) int {
+	// If the source sends duplicate metrics, only the last one is preserved.
+	uniqueMetrics := make(map[uint64]*dto.Metric)
+	for _, metric := range mf.Metric {
+		hash := getLabelHash(metric.Label)
+		uniqueMetrics[hash] = metric
+	}
+
+	// Rebuild slice if duplicates were removed
+	removed := len(mf.Metric) - len(uniqueMetrics)
+	if removed > 0 {
+		cleanMetrics := make([]*dto.Metric, 0, len(uniqueMetrics))
+		for _, m := range uniqueMetrics {
+			cleanMetrics = append(cleanMetrics, m)
+		}
+		mf.Metric = cleanMetrics
 	}
 
-	return families, nil
+	return removed
 }
 
 // getLabelHash generates a unique uint64 hash signature for a metric based on its labels.
@@ -3,6 +3,7 @@ package poller
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/oschwald/geoip2-golang"
@@ -12,11 +13,34 @@ import (
 	"github.com/woozymasta/metricz-exporter/internal/storage"
 )
 
+// Sources of polling workers.
+const (
+	sourceA2S  = "a2s"
+	sourceRCon = "rcon"
+)
+
 // Manager runs polling workers for configured servers.
 type Manager struct {
-	store *storage.Storage
-	cfg   *config.Config
-	geoDB *geoip2.Reader
+	ctx     context.Context
+	store   *storage.Storage
+	cfg     *config.Config
+	geoDB   *geoip2.Reader
+	workers map[workerKey]*worker
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+}
+
+// workerKey identifies the polling worker of one source of an instance.
+type workerKey struct {
+	instanceID string
+	source     string
+}
+
+// worker is a running poller with the definition it was started with.
+type worker struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	srv    config.ServerDefinition
 }
 
 // NewManager creates a new poller manager.
@@ -24,7 +48,7 @@ func NewManager(store *storage.Storage, cfg *config.Config) *Manager {
 	var geoDB *geoip2.Reader
 
 	if cfg.App.GeoIP.Path == "" {
-		return &Manager{store: store, cfg: cfg, geoDB: nil}
+		return &Manager{store: store, cfg: cfg, geoDB: nil, workers: make(map[workerKey]*worker)}
 	}
 
 	if cfg.App.GeoIP.URL != "" {
@@ -45,27 +69,106 @@ func NewManager(store *storage.Storage, cfg *config.Config) *Manager {
 		log.Info().Str("path", cfg.App.GeoIP.Path).Msg("GeoIP database loaded")
 	}
 
-	return &Manager{store: store, cfg: cfg, geoDB: geoDB}
+	return &Manager{store: store, cfg: cfg, geoDB: geoDB, workers: make(map[workerKey]*worker)}
 }
 
-// Close releases resources held by Manager.
+// Close releases resources held by Manager, call it after Wait.
 func (m *Manager) Close() {
 	if m.geoDB != nil {
 		_ = m.geoDB.Close()
 	}
 }
 
-// Start launches pollers until ctx is canceled.
+// Start launches pollers of configured servers until ctx is canceled.
 func (m *Manager) Start(ctx context.Context) {
-	for _, srv := range m.cfg.Servers {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.mu.Unlock()
+
+	m.Reconcile(m.cfg.Servers)
+}
+
+// Reconcile brings running pollers in line with servers: pollers of removed sources are stopped
+// and their metrics dropped, pollers with a changed definition are restarted, new ones are started.
+// Pollers of unchanged sources keep running with their sessions. Must be called after Start.
+func (m *Manager) Reconcile(servers []config.ServerDefinition) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ctx == nil || m.ctx.Err() != nil {
+		return
+	}
+
+	wanted := make(map[workerKey]config.ServerDefinition)
+	for _, srv := range servers {
 		if srv.A2S != nil && srv.A2S.Address != "" {
-			go m.runA2SWorker(ctx, srv)
+			wanted[workerKey{srv.InstanceID, sourceA2S}] = srv
 		}
-
 		if srv.RCon != nil && srv.RCon.Address != "" {
-			go m.runRConWorker(ctx, srv)
+			wanted[workerKey{srv.InstanceID, sourceRCon}] = srv
 		}
 	}
+
+	// Stop first, a restarted RCon poller must not hold two sessions at once
+	for key, w := range m.workers {
+		srv, ok := wanted[key]
+		if ok && sameSource(key.source, w.srv, srv) {
+			delete(wanted, key)
+			continue
+		}
+
+		w.cancel()
+		<-w.done
+		delete(m.workers, key)
+
+		if !ok {
+			m.dropMetrics(key)
+			log.Info().
+				Str("instance_id", key.instanceID).
+				Str("source", key.source).
+				Msg("poller removed")
+		}
+	}
+
+	for key, srv := range wanted {
+		ctx, cancel := context.WithCancel(m.ctx)
+		w := &worker{cancel: cancel, done: make(chan struct{}), srv: srv}
+		m.workers[key] = w
+
+		m.wg.Go(func() {
+			defer close(w.done)
+
+			if key.source == sourceA2S {
+				m.runA2SWorker(ctx, srv)
+			} else {
+				m.runRConWorker(ctx, srv)
+			}
+		})
+	}
+}
+
+// Wait blocks until all pollers stopped after ctx of Start is canceled,
+// RCon sessions are closed by then.
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+// sameSource reports whether the source definition of a server is unchanged.
+func sameSource(source string, a, b config.ServerDefinition) bool {
+	if source == sourceA2S {
+		return *a.A2S == *b.A2S
+	}
+
+	return *a.RCon == *b.RCon
+}
+
+// dropMetrics removes metrics of a stopped source from storage.
+func (m *Manager) dropMetrics(key workerKey) {
+	if key.source == sourceA2S {
+		m.store.UpdatePolled(key.instanceID, nil)
+	} else {
+		m.store.UpdateRCon(key.instanceID, nil)
+	}
 }
 
 func (m *Manager) runA2SWorker(ctx context.Context, srv config.ServerDefinition) {
@@ -80,6 +183,7 @@ func (m *Manager) runA2SWorker(ctx context.Context, srv config.ServerDefinition)
 	for {
 		select {
 		case <-ctx.Done():
+			log.Debug().Str("instance_id", srv.InstanceID).Msg("stopping A2S poller")
 			return
 
 		case <-ticker.C:
@@ -124,6 +228,7 @@ func (m *Manager) runRConWorker(ctx context.Context, srv config.ServerDefinition
 	for {
 		select {
 		case <-ctx.Done():
+			log.Debug().Str("instance_id", srv.InstanceID).Msg("stopping RCon poller")
 			return
 
 		case <-ticker.C:
@@ -0,0 +1,181 @@
+// Package relabel applies Prometheus-style metric relabeling rules to ingested metric families.
+package relabel
+
+import (
+	"crypto/md5" //nolint:gosec // sharding like Prometheus hashmod, not security
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/rs/zerolog/log"
+	"github.com/woozymasta/metricz-exporter/internal/config"
+	"github.com/woozymasta/metricz-exporter/internal/parser"
+)
+
+const (
+	// nameLabel exposes the metric (family) name to relabel rules.
+	nameLabel = model.MetricNameLabel
+
+	// instanceLabel is always restored after relabeling, rules can not move series between instances.
+	instanceLabel = "instance_id"
+)
+
+// Process applies rules to every series of the families and returns the resulting families.
+// Series are dropped by keep/drop actions or when the resulting metric name is empty or invalid.
+// Renamed series move to the family with the new name if its type matches, otherwise they are dropped.
+// Families are processed in name order, so the type of a target family shared by several
+// sources is the type of the first one and colliding series of other types are dropped consistently.
+// Labels with "__" prefix are removed after relabeling, use them as temporary labels.
+func Process(families map[string]*dto.MetricFamily, rules []config.RelabelConfig) map[string]*dto.MetricFamily {
+	if len(rules) == 0 {
+		return families
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make(map[string]*dto.MetricFamily, len(families))
+	for _, name := range names {
+		mf := families[name]
+		for _, m := range mf.Metric {
+			labels := make(map[string]string, len(m.Label)+1)
+			for _, lp := range m.Label {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			labels[nameLabel] = name
+			instanceID, hasInstanceID := labels[instanceLabel]
+
+			if !apply(labels, rules) {
+				continue
+			}
+
+			target := labels[nameLabel]
+			if !model.ValidationScheme.IsValidMetricName(model.UTF8Validation, target) {
+				log.Debug().
+					Str("metric", name).
+					Str("target", target).
+					Msg("relabeling produced invalid metric name, series dropped")
+				continue
+			}
+
+			if hasInstanceID {
+				labels[instanceLabel] = instanceID
+			}
+
+			out, ok := result[target]
+			if !ok {
+				out = &dto.MetricFamily{
+					Name: &target,
+					Help: mf.Help,
+					Type: mf.Type,
+					Unit: mf.Unit,
+				}
+				result[target] = out
+			} else if out.GetType() != mf.GetType() {
+				log.Debug().
+					Str("metric", name).
+					Str("target", target).
+					Msg("relabeling moved series into family of other type, series dropped")
+				continue
+			}
+
+			m.Label = toLabelPairs(labels)
+			out.Metric = append(out.Metric, m)
+		}
+	}
+
+	// Renames and label drops may produce duplicates
+	for _, mf := range result {
+		parser.Deduplicate(mf)
+	}
+
+	return result
+}
+
+// apply runs rules over the label set in place and reports whether the series is kept.
+func apply(labels map[string]string, rules []config.RelabelConfig) bool {
+	for i := range rules {
+		rule := &rules[i]
+
+		values := make([]string, 0, len(rule.SourceLabels))
+		for _, name := range rule.SourceLabels {
+			values = append(values, labels[name])
+		}
+		value := strings.Join(values, rule.Separator)
+
+		switch rule.Action {
+		case config.RelabelKeep:
+			if !rule.Regex.MatchString(value) {
+				return false
+			}
+
+		case config.RelabelDrop:
+			if rule.Regex.MatchString(value) {
+				return false
+			}
+
+		case config.RelabelReplace:
+			indexes := rule.Regex.FindStringSubmatchIndex(value)
+			if indexes == nil {
+				continue
+			}
+
+			target := string(rule.Regex.ExpandString(nil, rule.TargetLabel, value, indexes))
+			if !model.ValidationScheme.IsValidLabelName(model.UTF8Validation, target) {
+				continue
+			}
+
+			replacement := string(rule.Regex.ExpandString(nil, rule.GetReplacement(), value, indexes))
+			if replacement == "" {
+				delete(labels, target)
+			} else {
+				labels[target] = replacement
+			}
+
+		case config.RelabelHashMod:
+			sum := md5.Sum([]byte(value)) //nolint:gosec
+			mod := binary.BigEndian.Uint64(sum[8:]) % rule.Modulus
+			labels[rule.TargetLabel] = strconv.FormatUint(mod, 10)
+
+		case config.RelabelLabelDrop:
+			for name := range labels {
+				if name != nameLabel && rule.Regex.MatchString(name) {
+					delete(labels, name)
+				}
+			}
+
+		case config.RelabelLabelKeep:
+			for name := range labels {
+				if name != nameLabel && !rule.Regex.MatchString(name) {
+					delete(labels, name)
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// toLabelPairs converts the label set to sorted label pairs without "__" prefixed labels.
+func toLabelPairs(labels map[string]string) []*dto.LabelPair {
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for name, value := range labels {
+		if strings.HasPrefix(name, "__") || value == "" {
+			continue
+		}
+
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].GetName() < pairs[j].GetName()
+	})
+
+	return pairs
+}
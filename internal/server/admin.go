@@ -0,0 +1,271 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/hlog"
+	"github.com/woozymasta/metricz-exporter/internal/config"
+)
+
+// redactedSecret replaces secrets in admin responses. Sent back unchanged it keeps the current secret.
+const redactedSecret = "***"
+
+// maxServerBodySize limits the body of admin server requests.
+const maxServerBodySize = 64 << 10
+
+var (
+	errServerExists   = errors.New("server already exists")
+	errServerNotFound = errors.New("server not found")
+	errInvalidServer  = errors.New("invalid server")
+)
+
+// updateWarningHeader carries warnings of an applied server change, e.g. secrets to set for write-back.
+const updateWarningHeader = "X-MetricZ-Warning"
+
+// ServerRegistry changes servers of the running configuration.
+type ServerRegistry interface {
+	// UpdateServers replaces servers with the list returned by update and applies it,
+	// errors of update are returned unchanged. Warnings report problems of an applied change,
+	// e.g. secrets the config file refers to. Calls are serialized with config reloads.
+	UpdateServers(update func(servers []config.ServerDefinition) ([]config.ServerDefinition, error)) ([]string, error)
+}
+
+// RegisterAdminRoutes registers server management endpoints, protected by global Basic Auth.
+func (h *Handler) RegisterAdminRoutes(r chi.Router, registry ServerRegistry) {
+	r.Use(h.AdminEnabledMiddleware)
+	r.Use(h.BasicAuthMiddleware)
+
+	r.Get("/servers", h.handleListServers)
+	r.Get("/servers/{instance_id}", h.handleGetServer)
+	r.Post("/servers", func(w http.ResponseWriter, r *http.Request) {
+		h.handleAddServer(w, r, registry)
+	})
+	r.Put("/servers/{instance_id}", func(w http.ResponseWriter, r *http.Request) {
+		h.handleReplaceServer(w, r, registry)
+	})
+	r.Delete("/servers/{instance_id}", func(w http.ResponseWriter, r *http.Request) {
+		h.handleRemoveServer(w, r, registry)
+	})
+}
+
+// AdminEnabledMiddleware hides admin endpoints unless admin.enabled is set.
+func (h *Handler) AdminEnabledMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.config().App.Admin.Enabled {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) handleListServers(w http.ResponseWriter, _ *http.Request) {
+	servers := h.config().Servers
+
+	resp := make([]config.ServerDefinition, 0, len(servers))
+	for _, srv := range servers {
+		resp = append(resp, redactServer(srv))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) handleGetServer(w http.ResponseWriter, r *http.Request) {
+	srv := h.config().Server(chi.URLParam(r, "instance_id"))
+	if srv == nil {
+		http.Error(w, errServerNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, redactServer(*srv))
+}
+
+func (h *Handler) handleAddServer(w http.ResponseWriter, r *http.Request, registry ServerRegistry) {
+	srv, ok := readServer(w, r)
+	if !ok {
+		return
+	}
+
+	warnings, err := registry.UpdateServers(func(servers []config.ServerDefinition) ([]config.ServerDefinition, error) {
+		if indexServer(servers, srv.InstanceID) >= 0 {
+			return nil, errServerExists
+		}
+
+		servers = append(servers, srv)
+		return servers, h.validateServers(servers)
+	})
+	if !h.writeUpdateResult(w, r, warnings, err, "added", srv.InstanceID) {
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, redactServer(srv))
+}
+
+func (h *Handler) handleReplaceServer(w http.ResponseWriter, r *http.Request, registry ServerRegistry) {
+	instanceID := chi.URLParam(r, "instance_id")
+
+	srv, ok := readServer(w, r)
+	if !ok {
+		return
+	}
+	if srv.InstanceID == "" {
+		srv.InstanceID = instanceID
+	}
+	if srv.InstanceID != instanceID {
+		http.Error(w, "instance_id of body does not match URL", http.StatusBadRequest)
+		return
+	}
+
+	warnings, err := registry.UpdateServers(func(servers []config.ServerDefinition) ([]config.ServerDefinition, error) {
+		i := indexServer(servers, instanceID)
+		if i < 0 {
+			return nil, errServerNotFound
+		}
+
+		keepSecrets(&srv, servers[i])
+		servers[i] = srv
+		return servers, h.validateServers(servers)
+	})
+	if !h.writeUpdateResult(w, r, warnings, err, "updated", instanceID) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, redactServer(srv))
+}
+
+func (h *Handler) handleRemoveServer(w http.ResponseWriter, r *http.Request, registry ServerRegistry) {
+	instanceID := chi.URLParam(r, "instance_id")
+
+	warnings, err := registry.UpdateServers(func(servers []config.ServerDefinition) ([]config.ServerDefinition, error) {
+		i := indexServer(servers, instanceID)
+		if i < 0 {
+			return nil, errServerNotFound
+		}
+
+		return slices.Delete(servers, i, i+1), nil
+	})
+	if !h.writeUpdateResult(w, r, warnings, err, "removed", instanceID) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateServers checks the running config with servers replaced.
+func (h *Handler) validateServers(servers []config.ServerDefinition) error {
+	candidate := *h.config()
+	candidate.Servers = servers
+
+	if err := candidate.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidServer, err)
+	}
+
+	return nil
+}
+
+// writeUpdateResult writes the error response of a failed update and logs successful ones,
+// warnings of a successful update are set as X-MetricZ-Warning headers. It returns true if the update succeeded.
+func (h *Handler) writeUpdateResult(w http.ResponseWriter, r *http.Request, warnings []string, err error, action, instanceID string) bool {
+	logger := hlog.FromRequest(r)
+
+	switch {
+	case err == nil:
+		for _, warning := range warnings {
+			w.Header().Add(updateWarningHeader, warning)
+		}
+		logger.Info().Str("instance_id", instanceID).Strs("warnings", warnings).Msg("server " + action + " via admin API")
+		return true
+
+	case errors.Is(err, errServerExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+
+	case errors.Is(err, errServerNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+	case errors.Is(err, errInvalidServer):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+	default:
+		logger.Error().Err(err).Str("instance_id", instanceID).Msg("failed to apply server change")
+		http.Error(w, "failed to apply server change", http.StatusInternalServerError)
+	}
+
+	return false
+}
+
+// readServer decodes the server definition of the request body, on failure the response is written.
+func readServer(w http.ResponseWriter, r *http.Request) (config.ServerDefinition, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxServerBodySize))
+	if err != nil {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return config.ServerDefinition{}, false
+	}
+
+	srv, err := config.ParseServer(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return config.ServerDefinition{}, false
+	}
+
+	return srv, true
+}
+
+// indexServer returns the index of the instance in servers or -1.
+func indexServer(servers []config.ServerDefinition, instanceID string) int {
+	return slices.IndexFunc(servers, func(srv config.ServerDefinition) bool {
+		return srv.InstanceID == instanceID
+	})
+}
+
+// redactServer returns a copy of the definition with secrets replaced.
+func redactServer(srv config.ServerDefinition) config.ServerDefinition {
+	if srv.RCon != nil {
+		rcon := *srv.RCon
+		rcon.Password = redact(rcon.Password)
+		srv.RCon = &rcon
+	}
+
+	if srv.IngestAuth != nil {
+		auth := *srv.IngestAuth
+		auth.Pass = redact(auth.Pass)
+		auth.Token = redact(auth.Token)
+		auth.HMACSecret = redact(auth.HMACSecret)
+		srv.IngestAuth = &auth
+	}
+
+	return srv
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	return redactedSecret
+}
+
+// keepSecrets restores secrets of srv sent back redacted from the previous definition,
+// so a listed definition can be modified and sent back as is.
+func keepSecrets(srv *config.ServerDefinition, previous config.ServerDefinition) {
+	if srv.RCon != nil && srv.RCon.Password == redactedSecret && previous.RCon != nil {
+		srv.RCon.Password = previous.RCon.Password
+	}
+
+	if srv.IngestAuth != nil && previous.IngestAuth != nil {
+		if srv.IngestAuth.Pass == redactedSecret {
+			srv.IngestAuth.Pass = previous.IngestAuth.Pass
+		}
+		if srv.IngestAuth.Token == redactedSecret {
+			srv.IngestAuth.Token = previous.IngestAuth.Token
+		}
+		if srv.IngestAuth.HMACSecret == redactedSecret {
+			srv.IngestAuth.HMACSecret = previous.IngestAuth.HMACSecret
+		}
+	}
+}
@@ -3,19 +3,25 @@ package server
 import (
 	"crypto/subtle"
 	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/hlog"
+	"github.com/woozymasta/metricz-exporter/internal/config"
 )
 
 // BasicAuthMiddleware enforces Basic Authentication if credentials are configured.
 func (h *Handler) BasicAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// If auth is not configured, skip check
-		if h.cfg.App.Auth.User == "" || h.cfg.App.Auth.Pass == "" {
+		auth := h.config().App.Auth
+		if auth.User == "" || auth.Pass == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		user, pass, ok := r.BasicAuth()
-		if !ok || !h.checkCredentials(user, pass) {
+		if !ok || !checkCredentials(auth, user, pass) {
 			w.Header().Set("WWW-Authenticate", `Basic realm="MetricZ Exporter"`)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
@@ -25,10 +31,96 @@ func (h *Handler) BasicAuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// AuthRequiredMiddleware refuses requests unless global Basic Auth credentials are configured,
+// destructive endpoints must never be open when BasicAuthMiddleware skips the check.
+func (h *Handler) AuthRequiredMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := h.config().App.Auth
+		if auth.User == "" || auth.Pass == "" {
+			http.Error(w, "Forbidden: auth.user and auth.password are not configured", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // checkCredentials uses constant time comparison to prevent timing attacks.
-func (h *Handler) checkCredentials(user, pass string) bool {
-	userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(h.cfg.App.Auth.User)) == 1
-	passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(h.cfg.App.Auth.Pass)) == 1
+func checkCredentials(auth config.AuthConfig, user, pass string) bool {
+	userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(auth.User)) == 1
+	passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(auth.Pass)) == 1
 
 	return userMatch && passMatch
 }
+
+// IngestAuthMiddleware authenticates ingest requests for the instance from the URL.
+// Instances with ingest_auth accept only their own credentials (Basic Auth or Bearer token),
+// other instances fall back to the global Basic Auth. Credentials of one instance
+// never authorize pushes to another instance or scrapes of /metrics.
+func (h *Handler) IngestAuthMiddleware(next http.Handler) http.Handler {
+	global := h.BasicAuthMiddleware(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv := h.config().Server(chi.URLParam(r, "instance_id"))
+		if srv == nil || srv.IngestAuth == nil {
+			global.ServeHTTP(w, r)
+			return
+		}
+
+		// Signature only instances are verified by SignatureMiddleware
+		if !srv.IngestAuth.HasCredentials() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !checkIngestAuth(r, srv.IngestAuth) {
+			if srv.IngestAuth.Token != "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="MetricZ Exporter"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Basic realm="MetricZ Exporter"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkIngestAuth validates request credentials against instance ingest credentials in constant time.
+func checkIngestAuth(r *http.Request, auth *config.IngestAuthConfig) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return auth.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(auth.Token)) == 1
+	}
+
+	user, pass, ok := r.BasicAuth()
+	if !ok || auth.User == "" || auth.Pass == "" {
+		return false
+	}
+
+	userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(auth.User)) == 1
+	passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(auth.Pass)) == 1
+
+	return userMatch && passMatch
+}
+
+// InstanceAllowlistMiddleware rejects ingest requests for instance IDs not allowed by
+// ingest.allowed_instances before the body is read, so no instance state is created.
+func (h *Handler) InstanceAllowlistMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		instanceID := chi.URLParam(r, "instance_id")
+		if !h.config().IngestAllowed(instanceID) {
+			h.store.AddForbiddenIngest()
+
+			hlog.FromRequest(r).Warn().
+				Str("instance_id", instanceID).
+				Msg("ingest rejected, instance_id is not allowed")
+			http.Error(w, "instance_id is not allowed", http.StatusForbidden)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,7 +2,10 @@
 package server
 
 import (
+	"encoding/json"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -11,9 +14,13 @@ import (
 )
 
 // Handler manages the HTTP API endpoints.
+// Config and ingest limits are swapped atomically on reload, see UpdateConfig.
 type Handler struct {
 	store       *storage.Storage
-	cfg         *config.Config
+	cfg         atomic.Pointer[config.Config]
+	nonces      *nonceCache
+	limits      atomic.Pointer[ingestLimits]
+	metrics     *ingestMetrics
 	publicCache sync.Map
 }
 
@@ -24,16 +31,45 @@ type publicCacheItem struct {
 
 // NewHandler creates a new API handler with dependencies.
 func NewHandler(store *storage.Storage, cfg *config.Config) *Handler {
-	return &Handler{
-		store: store,
-		cfg:   cfg,
+	h := &Handler{
+		store:   store,
+		nonces:  newNonceCache(),
+		metrics: newIngestMetrics(),
 	}
+	h.cfg.Store(cfg)
+	h.limits.Store(newIngestLimits(cfg.App.Ingest.RateLimit))
+
+	return h
+}
+
+// config returns the current configuration.
+func (h *Handler) config() *config.Config {
+	return h.cfg.Load()
+}
+
+// UpdateConfig swaps the configuration used by handlers: auth, allowed instances, public export,
+// ingest limits and relabeling. Rate limiters are rebuilt only if their settings changed,
+// cached public responses are dropped.
+func (h *Handler) UpdateConfig(cfg *config.Config) {
+	previous := h.cfg.Swap(cfg)
+	if previous.App.Ingest.RateLimit != cfg.App.Ingest.RateLimit {
+		h.limits.Store(newIngestLimits(cfg.App.Ingest.RateLimit))
+	}
+
+	h.publicCache.Clear()
 }
 
 // RegisterPrivateRoutes registers authenticated ingest endpoints under /api/v1.
 func (h *Handler) RegisterPrivateRoutes(r chi.Router) {
-	// Apply Basic Auth to this group
-	r.Use(h.BasicAuthMiddleware)
+	// Count requests rejected by auth and allowlist as well
+	r.Use(h.IngestMetricsMiddleware)
+	// Apply per-instance or global Basic Auth to this group
+	r.Use(h.IngestAuthMiddleware)
+	r.Use(h.InstanceAllowlistMiddleware)
+	// Throttle before the body is read, hashed or decompressed
+	r.Use(h.RateLimitMiddleware)
+	r.Use(h.SignatureMiddleware)
+	r.Use(h.DecompressMiddleware)
 	r.Use(h.JSONTranslatorMiddleware)
 
 	// Single-shot upload (entire payload in one request)
@@ -41,7 +77,11 @@ func (h *Handler) RegisterPrivateRoutes(r chi.Router) {
 
 	// Chunked upload (transaction-based)
 	r.Post("/ingest/{instance_id}/{txn_hash}/{seq_id}", h.handleChunkIngest)
+	r.Get("/ingest/{instance_id}/{txn_hash}", h.handleTransactionStatus)
 	r.Post("/commit/{instance_id}/{txn_hash}", h.handleCommit)
+
+	// Dry-run of the ingest pipeline, nothing is stored
+	r.Post("/validate/{instance_id}", h.handleValidate)
 }
 
 // RegisterUI registers the web interface routes.
@@ -63,3 +103,11 @@ func (h *Handler) RegisterHealthRoutes(r chi.Router) {
 	r.Get("/health/liveness", h.HandleLiveness)
 	r.Get("/health/readiness", h.HandleReadiness)
 }
+
+// writeJSON sends v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -1,13 +1,29 @@
 package server
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/hlog"
 	"github.com/woozymasta/metricz-exporter/internal/parser"
+	"github.com/woozymasta/metricz-exporter/internal/storage"
+)
+
+// Commit integrity headers, alternatives to "chunks" and "digest" query parameters.
+const (
+	commitChunksHeader = "X-MetricZ-Chunks"
+	commitDigestHeader = "X-MetricZ-Digest"
+
+	// commitReplayedHeader marks a response replayed for a retried commit.
+	commitReplayedHeader = "X-MetricZ-Commit-Replayed"
 )
 
 func (h *Handler) handleChunkIngest(w http.ResponseWriter, r *http.Request) {
@@ -17,6 +33,9 @@ func (h *Handler) handleChunkIngest(w http.ResponseWriter, r *http.Request) {
 	logger := hlog.FromRequest(r)
 
 	seqID, err := strconv.Atoi(seqIDStr)
+	if err == nil && seqID < storage.FirstSeqID {
+		err = fmt.Errorf("seq_id must not be below %d", storage.FirstSeqID)
+	}
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -27,20 +46,32 @@ func (h *Handler) handleChunkIngest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bodyReader := http.MaxBytesReader(w, r.Body, h.cfg.App.Ingest.MaxBodySize)
+	bodyReader := http.MaxBytesReader(w, r.Body, h.config().App.Ingest.MaxBodySize)
 	body, err := io.ReadAll(bodyReader)
 	if err != nil {
 		logger.Error().
 			Err(err).
 			Str("instance_id", instanceID).
 			Msg("failed to read chunk body")
-		http.Error(w, "failed to read body", http.StatusInternalServerError)
+
+		var maxErr *http.MaxBytesError
+		var decErr *decodeError
+		switch {
+		case errors.As(err, &maxErr):
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		case errors.As(err, &decErr):
+			http.Error(w, decErr.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "failed to read body", http.StatusInternalServerError)
+		}
 
 		return
 	}
 	defer func() { _ = r.Body.Close() }()
 
-	err = h.store.AppendToStaging(txnHash, instanceID, seqID, body, h.cfg.App.Ingest.TransactionTTL.ToDuration())
+	cfg := h.config()
+	err = h.store.AppendToStaging(txnHash, instanceID, seqID, body,
+		cfg.App.Ingest.TransactionTTL.ToDuration(), cfg.StagingQuota(instanceID))
 	if err != nil {
 		logger.Warn().
 			Err(err).
@@ -48,11 +79,32 @@ func (h *Handler) handleChunkIngest(w http.ResponseWriter, r *http.Request) {
 			Str("instance_id", instanceID).
 			Int("seq_id", seqID).
 			Msg("staging rejected chunk")
-		http.Error(w, "staging buffer full", http.StatusServiceUnavailable)
+
+		if reason := stagingErrorReason(err); reason != "" {
+			h.metrics.parseError(instanceID, reason)
+		}
+
+		switch {
+		case errors.Is(err, storage.ErrTransactionOwner):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, storage.ErrInstanceStagingFull):
+			// Waiting does not help, the transaction itself is too large
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, storage.ErrTransactionNotFound):
+			// Expired, evicted or committed while the chunk was written, the upload must start over
+			http.Error(w, "transaction dropped while staging the chunk", http.StatusConflict)
+		case errors.Is(err, storage.ErrStagingFull):
+			setRetryAfter(w, h.store.StagingRetryAfter())
+			http.Error(w, "staging buffer full", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "failed to stage chunk", http.StatusInternalServerError)
+		}
 
 		return
 	}
 
+	h.recordBodyStats(r, instanceID)
+
 	logger.Trace().
 		Str("txn", txnHash).
 		Str("instance_id", instanceID).
@@ -69,43 +121,270 @@ func (h *Handler) handleCommit(w http.ResponseWriter, r *http.Request) {
 	instanceID := chi.URLParam(r, "instance_id")
 	txnHash := chi.URLParam(r, "txn_hash")
 	logger := hlog.FromRequest(r)
+	var opts storage.RetrieveOptions
 
-	reader, chunkCount, totalBytes, ok := h.store.RetrieveStaging(txnHash)
+	// Payload format, ingest mode and integrity expectations are declared by the commit request,
+	// chunks are opaque
+	mode, err := ingestModeFromRequest(r)
+	if err == nil {
+		opts, err = commitOptions(r, txnHash)
+	}
+	if err != nil {
+		logger.Warn().
+			Err(err).
+			Str("instance_id", instanceID).
+			Str("txn", txnHash).
+			Msg("commit rejected")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	// Slot is taken before the transaction leaves staging, a busy server keeps it for a retry
+	release, ok := h.acquireParseSlot(w, r)
 	if !ok {
+		return
+	}
+	defer release()
+
+	format := requestFormat(r)
+	opts.InstanceID = instanceID
+	opts.JoinLines = format.LineBased()
+
+	staged, err := h.store.RetrieveStaging(txnHash, opts)
+	if err != nil {
+		var integrityErr *storage.IntegrityError
+		if errors.As(err, &integrityErr) {
+			logger.Warn().
+				Err(err).
+				Str("instance_id", instanceID).
+				Str("txn", txnHash).
+				Msg("commit failed")
+			h.store.AddCommitFailure(instanceID, integrityErr.Reason)
+			writeJSON(w, http.StatusUnprocessableEntity, integrityErr)
+
+			return
+		}
+
+		// Retried commit of an already committed transaction gets the original result
+		if result, ok := h.store.CommitResult(txnHash, instanceID); ok {
+			logger.Debug().
+				Str("instance_id", instanceID).
+				Str("txn", txnHash).
+				Int("status", result.Status).
+				Msg("commit retried, replaying result")
+			w.Header().Set(commitReplayedHeader, "true")
+			writeCommitResult(w, result)
+
+			return
+		}
+
 		logger.Warn().
+			Err(err).
 			Str("instance_id", instanceID).
 			Str("txn", txnHash).
-			Msg("commit failed, transaction not found or empty")
+			Msg("commit failed")
+		h.metrics.parseError(instanceID, parseErrorTxnNotFound)
 		http.Error(w, "Transaction not found or empty", http.StatusNotFound)
 
 		return
 	}
 
-	metrics, err := parser.ParseAndValidate(reader, instanceID, h.cfg.App.Ingest.OverwriteInstanceID)
+	defer func() {
+		if err := staged.Close(); err != nil {
+			logger.Warn().
+				Err(err).
+				Str("txn", txnHash).
+				Msg("failed to release staged chunks")
+		}
+	}()
+
+	result := h.applyCommit(r, txnHash, mode, format, staged)
+	h.store.RecordCommit(txnHash, result, h.config().App.Ingest.CommitResultTTL.ToDuration())
+	writeCommitResult(w, result)
+}
+
+// applyCommit parses and stores a staged payload, the outcome is returned as commit result.
+func (h *Handler) applyCommit(r *http.Request, txnHash string, mode ingestMode, format parser.Format, staged *storage.StagedPayload) storage.CommitResult {
+	instanceID := chi.URLParam(r, "instance_id")
+	logger := hlog.FromRequest(r)
+	result := storage.CommitResult{
+		CommittedAt: time.Now(),
+		InstanceID:  instanceID,
+		Chunks:      staged.Chunks,
+		Bytes:       staged.Bytes,
+	}
+
+	started := time.Now()
+	metrics, err := parser.ParseAndValidate(staged.Reader, format, instanceID, h.config().App.Ingest.OverwriteInstanceID)
+	h.metrics.parsed(instanceID, endpointCommit, started, staged.Bytes, err)
 	if err != nil {
+		h.metrics.parseError(instanceID, parseErrorReason(err))
+
 		logger.Warn().
 			Err(err).
 			Str("instance_id", instanceID).
 			Str("txn", txnHash).
-			Int("chunks", chunkCount).
-			Int("total_bytes", totalBytes).
+			Stringer("format", format).
+			Int("chunks", staged.Chunks).
+			Int("total_bytes", staged.Bytes).
 			Msg("commit validation failed")
-		http.Error(w, err.Error(), http.StatusBadRequest)
 
-		return
+		result.Status, result.Message = http.StatusBadRequest, err.Error()
+		return result
 	}
 
-	h.store.UpdateIngested(instanceID, metrics, totalBytes, chunkCount)
+	metrics, rejected, err := h.processIngested(instanceID, mode, metrics)
+	if err != nil {
+		logger.Warn().
+			Err(err).
+			Str("instance_id", instanceID).
+			Str("txn", txnHash).
+			Msg("commit rejected by limits")
+
+		result.Status, result.Message = http.StatusUnprocessableEntity, err.Error()
+		return result
+	}
+
+	h.storeIngested(mode, instanceID, metrics, staged.Bytes, staged.Chunks)
 
 	logger.Debug().
 		Str("instance_id", instanceID).
 		Str("txn", txnHash).
-		Int("chunks", chunkCount).
-		Int("total_bytes", totalBytes).
+		Stringer("mode", mode).
+		Int("chunks", staged.Chunks).
+		Int("total_bytes", staged.Bytes).
 		Int("families", len(metrics)).
+		Int("rejected_series", rejected).
 		Msg("transaction committed")
 
-	w.Header().Set("Content-Type", "text/plain")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte("OK"))
+	result.Status, result.Rejected = http.StatusOK, rejected
+	return result
+}
+
+// writeCommitResult sends the response of a commit, also used to replay it for retried commits.
+func writeCommitResult(w http.ResponseWriter, result storage.CommitResult) {
+	if result.Status != http.StatusOK {
+		http.Error(w, result.Message, result.Status)
+		return
+	}
+
+	writeIngestOK(w, result.Rejected)
+}
+
+// transactionStatus is the response of the transaction status endpoint.
+type transactionStatus struct {
+	CommittedAt *time.Time `json:"committed_at,omitempty"`
+	Txn         string     `json:"txn"`
+	State       string     `json:"state"`
+	Message     string     `json:"message,omitempty"`
+	Received    []int      `json:"received,omitempty"`
+	Missing     []int      `json:"missing,omitempty"`
+	ExpiresIn   float64    `json:"expires_in_seconds,omitempty"`
+	Bytes       int64      `json:"bytes"`
+	Chunks      int        `json:"chunks"`
+	Status      int        `json:"status,omitempty"`
+	Rejected    int        `json:"rejected_series,omitempty"`
+}
+
+// handleTransactionStatus reports received chunks of a staged transaction, so an interrupted
+// upload can resend only missing chunks, or the result of an already committed transaction.
+func (h *Handler) handleTransactionStatus(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instance_id")
+	txnHash := chi.URLParam(r, "txn_hash")
+
+	if info, ok := h.store.TransactionInfo(txnHash, instanceID); ok {
+		writeJSON(w, http.StatusOK, transactionStatus{
+			Txn:       txnHash,
+			State:     "staging",
+			Received:  info.SeqIDs,
+			Missing:   missingSeqIDs(info.SeqIDs),
+			ExpiresIn: time.Until(info.ExpiresAt).Seconds(),
+			Bytes:     info.ByteSize,
+			Chunks:    len(info.SeqIDs),
+		})
+
+		return
+	}
+
+	if result, ok := h.store.CommitResult(txnHash, instanceID); ok {
+		writeJSON(w, http.StatusOK, transactionStatus{
+			CommittedAt: &result.CommittedAt,
+			Txn:         txnHash,
+			State:       "committed",
+			Message:     result.Message,
+			Bytes:       int64(result.Bytes),
+			Chunks:      result.Chunks,
+			Status:      result.Status,
+			Rejected:    result.Rejected,
+		})
+
+		return
+	}
+
+	http.Error(w, "Transaction not found", http.StatusNotFound)
+}
+
+// maxStatusMissing bounds the list of missing seq IDs in the transaction status.
+const maxStatusMissing = 1024
+
+// missingSeqIDs returns seq IDs absent between the lowest and highest received ones,
+// the expected start is known only at commit.
+func missingSeqIDs(received []int) []int {
+	if len(received) == 0 {
+		return nil
+	}
+
+	var missing []int
+	next := received[0]
+	for _, seq := range received {
+		for ; next < seq && len(missing) < maxStatusMissing; next++ {
+			missing = append(missing, next)
+		}
+		next = seq + 1
+	}
+
+	return missing
+}
+
+// commitOptions reads integrity expectations of a commit from query parameters or headers:
+// "chunks" (X-MetricZ-Chunks) is the total chunk count and
+// "digest" (X-MetricZ-Digest) is "sha256:<hex>" of the concatenated chunks or "txn"
+// when txn_hash itself is the hex SHA-256 digest.
+func commitOptions(r *http.Request, txnHash string) (storage.RetrieveOptions, error) {
+	var opts storage.RetrieveOptions
+	query := r.URL.Query()
+
+	chunks := query.Get("chunks")
+	if chunks == "" {
+		chunks = r.Header.Get(commitChunksHeader)
+	}
+	if chunks != "" {
+		n, err := strconv.Atoi(chunks)
+		if err != nil || n <= 0 {
+			return opts, fmt.Errorf("invalid chunks count %q", chunks)
+		}
+		opts.ExpectedChunks = n
+	}
+
+	digest := query.Get("digest")
+	if digest == "" {
+		digest = r.Header.Get(commitDigestHeader)
+	}
+	if digest == "" {
+		return opts, nil
+	}
+
+	hexDigest, ok := strings.CutPrefix(digest, "sha256:")
+	if digest == "txn" {
+		hexDigest, ok = txnHash, true
+	}
+
+	sum, err := hex.DecodeString(hexDigest)
+	if !ok || err != nil || len(sum) != sha256.Size {
+		return opts, fmt.Errorf("invalid digest %q, expected sha256:<hex> or txn", digest)
+	}
+	opts.Digest = sum
+
+	return opts, nil
 }
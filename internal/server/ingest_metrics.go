@@ -0,0 +1,193 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/woozymasta/metricz-exporter/internal/parser"
+	"github.com/woozymasta/metricz-exporter/internal/storage"
+)
+
+// Endpoints of ingest request metrics.
+const (
+	endpointIngest   = "ingest"
+	endpointChunk    = "chunk"
+	endpointStatus   = "status"
+	endpointCommit   = "commit"
+	endpointValidate = "validate"
+)
+
+// resultUnauthorized is the request result of 401 and 403 responses.
+const resultUnauthorized = "unauthorized"
+
+// Reasons of ingest parse errors, used as "reason" label values.
+const (
+	parseErrorInstanceMismatch = "instance_mismatch"
+	parseErrorSyntax           = "syntax"
+	parseErrorBodyTooLarge     = "body_too_large"
+	parseErrorStagingFull      = "staging_full"
+	parseErrorTxnNotFound      = "txn_not_found"
+)
+
+// ingestMetrics accounts ingest requests by outcome, failures, parse durations and payload sizes.
+type ingestMetrics struct {
+	requests      *prometheus.CounterVec
+	parseErrors   *prometheus.CounterVec
+	parseDuration *prometheus.HistogramVec
+	payloadSize   *prometheus.HistogramVec
+}
+
+func newIngestMetrics() *ingestMetrics {
+	return &ingestMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "metricz_ingest_requests_total",
+			Help: "Total ingest API requests by endpoint and result.",
+		}, []string{"instance_id", "endpoint", "result"}),
+		parseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "metricz_ingest_parse_errors_total",
+			Help: "Total ingest payloads and chunks refused before they were stored.",
+		}, []string{"instance_id", "reason"}),
+		parseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "metricz_ingest_parse_duration_seconds",
+			Help:    "Duration of parsing and validation of ingest payloads.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"instance_id", "endpoint"}),
+		payloadSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "metricz_ingest_payload_bytes",
+			Help:    "Size of successfully parsed ingest payloads in bytes (after decompression).",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 8), // 1 KiB .. 16 MiB
+		}, []string{"instance_id", "endpoint"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *ingestMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.parseErrors.Describe(ch)
+	m.parseDuration.Describe(ch)
+	m.payloadSize.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *ingestMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.parseErrors.Collect(ch)
+	m.parseDuration.Collect(ch)
+	m.payloadSize.Collect(ch)
+}
+
+// parseError counts a refused payload or chunk.
+func (m *ingestMetrics) parseError(instanceID, reason string) {
+	m.parseErrors.WithLabelValues(instanceID, reason).Inc()
+}
+
+// parsed records duration and size of a parsed payload, size is recorded only on success.
+func (m *ingestMetrics) parsed(instanceID, endpoint string, started time.Time, size int, err error) {
+	m.parseDuration.WithLabelValues(instanceID, endpoint).Observe(time.Since(started).Seconds())
+	if err == nil {
+		m.payloadSize.WithLabelValues(instanceID, endpoint).Observe(float64(size))
+	}
+}
+
+// forget drops all series of the instance.
+func (m *ingestMetrics) forget(instanceID string) {
+	labels := prometheus.Labels{"instance_id": instanceID}
+	m.requests.DeletePartialMatch(labels)
+	m.parseErrors.DeletePartialMatch(labels)
+	m.parseDuration.DeletePartialMatch(labels)
+	m.payloadSize.DeletePartialMatch(labels)
+}
+
+// ForgetInstance drops ingest request metrics of an evicted or deleted instance.
+func (h *Handler) ForgetInstance(instanceID string) {
+	h.metrics.forget(instanceID)
+}
+
+// Collector returns the collector of ingest request metrics.
+func (h *Handler) Collector() prometheus.Collector {
+	return h.metrics
+}
+
+// IngestMetricsMiddleware counts ingest requests by endpoint and result derived from the status code.
+// It runs before instance authorization, requests rejected by it for instances not listed in servers
+// are counted with an empty instance_id, so unauthenticated clients can not create series.
+func (h *Handler) IngestMetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		instanceID := chi.URLParam(r, "instance_id")
+		result := requestResult(ww.Status())
+		if result == resultUnauthorized && h.config().Server(instanceID) == nil {
+			instanceID = ""
+		}
+
+		h.metrics.requests.WithLabelValues(instanceID, requestEndpoint(r), result).Inc()
+	})
+}
+
+// requestEndpoint names the ingest endpoint of the routed request.
+func requestEndpoint(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(chi.RouteContext(r.Context()).RoutePattern(), "/api/v1/validate/"):
+		return endpointValidate
+	case chi.URLParam(r, "seq_id") != "":
+		return endpointChunk
+	case r.Method == http.MethodGet:
+		return endpointStatus
+	case chi.URLParam(r, "txn_hash") != "":
+		return endpointCommit
+	default:
+		return endpointIngest
+	}
+}
+
+// requestResult maps the response status code to a "result" label value.
+func requestResult(status int) string {
+	switch {
+	case status == 0 || status < 300:
+		return "ok"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return resultUnauthorized
+	case status == http.StatusNotFound:
+		return "not_found"
+	case status == http.StatusRequestEntityTooLarge:
+		return "too_large"
+	case status == http.StatusUnprocessableEntity:
+		return "rejected"
+	case status == http.StatusTooManyRequests:
+		return "throttled"
+	case status == http.StatusServiceUnavailable:
+		return "unavailable"
+	case status < 500:
+		return "invalid"
+	default:
+		return "error"
+	}
+}
+
+// parseErrorReason classifies errors of parser.ParseAndValidate.
+func parseErrorReason(err error) string {
+	switch {
+	case isBodyTooLarge(err):
+		return parseErrorBodyTooLarge
+	case errors.Is(err, parser.ErrInstanceMismatch):
+		return parseErrorInstanceMismatch
+	default:
+		return parseErrorSyntax
+	}
+}
+
+// stagingErrorReason classifies errors of storage.AppendToStaging, empty if not counted.
+func stagingErrorReason(err error) string {
+	if errors.Is(err, storage.ErrStagingFull) || errors.Is(err, storage.ErrInstanceStagingFull) {
+		return parseErrorStagingFull
+	}
+
+	return ""
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ingestModeHeader selects the ingest mode when the "mode" query parameter is not set.
+const ingestModeHeader = "X-MetricZ-Ingest-Mode"
+
+// ingestMode selects how an ingest payload is applied to the instance state.
+type ingestMode int
+
+const (
+	// ingestReplace replaces all ingested families of the instance (full snapshot).
+	ingestReplace ingestMode = iota
+
+	// ingestMerge updates only families contained in the payload and applies tombstones.
+	ingestMerge
+)
+
+// String returns the mode name used in query and header values.
+func (m ingestMode) String() string {
+	if m == ingestMerge {
+		return "merge"
+	}
+
+	return "replace"
+}
+
+// ingestModeFromRequest reads the ingest mode from the "mode" query parameter
+// or the X-MetricZ-Ingest-Mode header, replace is used if both are empty.
+func ingestModeFromRequest(r *http.Request) (ingestMode, error) {
+	value := r.URL.Query().Get("mode")
+	if value == "" {
+		value = r.Header.Get(ingestModeHeader)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "replace":
+		return ingestReplace, nil
+	case "merge":
+		return ingestMerge, nil
+	default:
+		return ingestReplace, fmt.Errorf("unsupported ingest mode %q", value)
+	}
+}
+
+// storeIngested applies parsed families to the instance state according to the ingest mode.
+func (h *Handler) storeIngested(mode ingestMode, instanceID string, families map[string]*dto.MetricFamily, bytesAdded, chunksAdded int) {
+	if mode == ingestMerge {
+		h.store.MergeIngested(instanceID, families, bytesAdded, chunksAdded)
+		return
+	}
+
+	h.store.UpdateIngested(instanceID, families, bytesAdded, chunksAdded)
+}
@@ -1,10 +1,15 @@
 package server
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/rs/zerolog/hlog"
 	"github.com/woozymasta/metricz-exporter/internal/parser"
 )
@@ -25,22 +30,47 @@ func (h *Handler) handleSingleShot(w http.ResponseWriter, r *http.Request) {
 	instanceID := chi.URLParam(r, "instance_id")
 	logger := hlog.FromRequest(r)
 
-	limitedReader := http.MaxBytesReader(w, r.Body, h.cfg.App.Ingest.MaxBodySize)
-	counter := &countingReader{r: limitedReader}
-	defer func() { _ = r.Body.Close() }()
+	mode, err := ingestModeFromRequest(r)
+	if err != nil {
+		logger.Warn().
+			Err(err).
+			Str("instance_id", instanceID).
+			Msg("single-shot ingest rejected")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	// Body is buffered before a parse slot is taken, slow senders must not hold slots
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.config().App.Ingest.MaxBodySize))
+	_ = r.Body.Close()
+
+	format := requestFormat(r)
+	readBytes := len(body)
+	var metrics map[string]*dto.MetricFamily
+	if err == nil {
+		release, ok := h.acquireParseSlot(w, r)
+		if !ok {
+			return
+		}
+		defer release()
 
-	metrics, err := parser.ParseAndValidate(counter, instanceID, h.cfg.App.Ingest.OverwriteInstanceID)
-	readBytes := int(counter.count)
+		started := time.Now()
+		metrics, err = parser.ParseAndValidate(bytes.NewReader(body), format, instanceID, h.config().App.Ingest.OverwriteInstanceID)
+		h.metrics.parsed(instanceID, endpointIngest, started, readBytes, err)
+	}
 
 	if err != nil {
+		h.metrics.parseError(instanceID, parseErrorReason(err))
+
 		logger.Warn().
 			Err(err).
 			Str("instance_id", instanceID).
+			Stringer("format", format).
 			Int("read_bytes", readBytes).
 			Msg("single-shot ingest validation failed")
 
-		if err.Error() == "http: request body too large" ||
-			(err.Error() != "" && containsBodyTooLarge(err.Error())) {
+		if isBodyTooLarge(err) {
 			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
 			return
 		}
@@ -50,19 +80,33 @@ func (h *Handler) handleSingleShot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.store.UpdateIngested(instanceID, metrics, readBytes, 1)
+	metrics, rejected, err := h.processIngested(instanceID, mode, metrics)
+	if err != nil {
+		logger.Warn().
+			Err(err).
+			Str("instance_id", instanceID).
+			Msg("single-shot ingest rejected by limits")
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+
+		return
+	}
+
+	h.storeIngested(mode, instanceID, metrics, readBytes, 1)
+	h.recordBodyStats(r, instanceID)
 
 	logger.Debug().
 		Str("instance_id", instanceID).
+		Stringer("mode", mode).
 		Int("families", len(metrics)).
+		Int("rejected_series", rejected).
 		Int("bytes", readBytes).
 		Msg("single-shot metrics updated")
 
-	w.Header().Set("Content-Type", "text/plain")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte("OK"))
+	writeIngestOK(w, rejected)
 }
 
-func containsBodyTooLarge(s string) bool {
-	return len(s) >= 26 && s[len(s)-26:] == "http: request body too large"
+// isBodyTooLarge detects MaxBytesReader errors, decoders do not always wrap them.
+func isBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr) || strings.HasSuffix(err.Error(), "http: request body too large")
 }
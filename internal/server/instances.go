@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/hlog"
+)
+
+// instanceInfo is the lifecycle view of one instance, zero times of unused sources are omitted.
+type instanceInfo struct {
+	LastSeen   time.Time `json:"last_seen"`
+	LastIngest time.Time `json:"last_ingest,omitzero"`
+	LastA2S    time.Time `json:"last_a2s,omitzero"`
+	LastRCon   time.Time `json:"last_rcon,omitzero"`
+	LastStatsD time.Time `json:"last_statsd,omitzero"`
+	EvictAt    time.Time `json:"evict_at,omitzero"`
+	Configured bool      `json:"configured"`
+}
+
+// RegisterInstanceRoutes registers instance lifecycle endpoints under /api/v1, protected by global Basic Auth.
+// Deletion requires configured credentials, per-instance ingest credentials never authorize them.
+func (h *Handler) RegisterInstanceRoutes(r chi.Router) {
+	r.Use(h.BasicAuthMiddleware)
+
+	r.Get("/instances", h.handleListInstances)
+	r.With(h.AuthRequiredMiddleware).Delete("/instances/{instance_id}", h.handleDeleteInstance)
+}
+
+func (h *Handler) handleListInstances(w http.ResponseWriter, _ *http.Request) {
+	cfg := h.config()
+	maxAge := cfg.App.Stale.InstanceMaxAge.ToDuration()
+
+	states := h.store.GetInstanceStates()
+	resp := make(map[string]instanceInfo, len(states))
+	for id, state := range states {
+		info := instanceInfo{
+			LastSeen:   state.LastSeen(),
+			LastIngest: state.LastIngestUpdate,
+			LastA2S:    state.LastA2SUpdate,
+			LastRCon:   state.LastRConUpdate,
+			LastStatsD: state.LastStatsDUpdate,
+			Configured: cfg.Server(id) != nil,
+		}
+		if maxAge > 0 {
+			info.EvictAt = info.LastSeen.Add(maxAge)
+		}
+
+		resp[id] = info
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) handleDeleteInstance(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instance_id")
+
+	if !h.store.DeleteInstance(instanceID) {
+		http.Error(w, "Instance not found", http.StatusNotFound)
+		return
+	}
+
+	// Public status must not serve the deleted instance from cache
+	h.publicCache.Clear()
+
+	hlog.FromRequest(r).Info().Str("instance_id", instanceID).Msg("instance deleted via API")
+	w.WriteHeader(http.StatusNoContent)
+}
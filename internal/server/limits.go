@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/woozymasta/metricz-exporter/internal/config"
+	"github.com/woozymasta/metricz-exporter/internal/storage"
+)
+
+// rejectedSeriesHeader reports the number of series dropped by the truncate limits policy.
+const rejectedSeriesHeader = "X-MetricZ-Rejected-Series"
+
+// Reasons of series rejected by ingest limits, used as "reason" label values.
+const (
+	limitReasonLabelValue      = "label_value_length"
+	limitReasonSeriesPerFamily = "max_series_per_family"
+	limitReasonFamilies        = "max_families"
+	limitReasonSeries          = "max_series"
+)
+
+// limitError is returned when a payload exceeds ingest limits under the reject policy.
+type limitError struct {
+	reason string
+	detail string
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("ingest limit %s exceeded: %s", e.reason, e.detail)
+}
+
+// limitChecker collects series dropped by every limit and the first violation.
+type limitChecker struct {
+	first   *limitError
+	dropped map[string]int
+}
+
+// limitResult is the outcome of enforceLimits, it is not recorded in storage.
+type limitResult struct {
+	// err is set when the payload is rejected, then total is the payload series count.
+	err *limitError
+
+	// dropped counts series dropped by the truncate policy by reason.
+	dropped map[string]int
+	total   int
+}
+
+// rejected returns the number of series dropped by the truncate policy.
+func (res limitResult) rejected() int {
+	count := 0
+	for _, n := range res.dropped {
+		count += n
+	}
+
+	return count
+}
+
+// record accounts rejected series of the result in storage.
+func (res limitResult) record(store *storage.Storage, instanceID string) {
+	if res.err != nil {
+		store.AddRejectedSeries(instanceID, res.err.reason, res.total)
+		return
+	}
+
+	for reason, count := range res.dropped {
+		store.AddRejectedSeries(instanceID, reason, count)
+	}
+}
+
+func (c *limitChecker) drop(reason string, count int, format string, args ...any) {
+	if count == 0 {
+		return
+	}
+
+	if c.first == nil {
+		c.first = &limitError{reason: reason, detail: fmt.Sprintf(format, args...)}
+	}
+	c.dropped[reason] += count
+}
+
+// enforceLimits applies cardinality limits to the payload in place.
+// Under the reject policy any violation rejects the whole payload (limitResult.err is set),
+// under the truncate policy excess series are dropped and counted by reason.
+// Series are kept in stable label order, so truncation keeps the same series between pushes.
+func (h *Handler) enforceLimits(instanceID string, mode ingestMode, families map[string]*dto.MetricFamily) limitResult {
+	limits := h.config().App.Ingest.Limits
+	checker := &limitChecker{dropped: make(map[string]int)}
+
+	names := make([]string, 0, len(families))
+	total := 0
+	for name, mf := range families {
+		if name == storage.TombstoneFamily {
+			continue
+		}
+		names = append(names, name)
+		total += len(mf.Metric)
+	}
+	sort.Strings(names)
+
+	if limits.MaxLabelValueLength > 0 {
+		for _, name := range names {
+			mf := families[name]
+			kept := slices.DeleteFunc(slices.Clone(mf.Metric), func(m *dto.Metric) bool {
+				return hasLongLabelValue(m, limits.MaxLabelValueLength)
+			})
+			checker.drop(limitReasonLabelValue, len(mf.Metric)-len(kept),
+				"family '%s' has label values longer than %d bytes", name, limits.MaxLabelValueLength)
+			mf.Metric = kept
+		}
+	}
+
+	if limits.MaxSeriesPerFamily > 0 {
+		for _, name := range names {
+			mf := families[name]
+			if len(mf.Metric) <= limits.MaxSeriesPerFamily {
+				continue
+			}
+
+			checker.drop(limitReasonSeriesPerFamily, len(mf.Metric)-limits.MaxSeriesPerFamily,
+				"family '%s' has %d series, limit %d", name, len(mf.Metric), limits.MaxSeriesPerFamily)
+			sortSeries(mf.Metric)
+			mf.Metric = mf.Metric[:limits.MaxSeriesPerFamily]
+		}
+	}
+
+	if limits.MaxFamilies > 0 && len(names) > limits.MaxFamilies {
+		count := 0
+		for _, name := range names[limits.MaxFamilies:] {
+			count += len(families[name].Metric)
+			delete(families, name)
+		}
+
+		checker.drop(limitReasonFamilies, count,
+			"payload has %d families, limit %d", len(names), limits.MaxFamilies)
+		names = names[:limits.MaxFamilies]
+	}
+
+	if limits.MaxSeries > 0 {
+		budget := limits.MaxSeries
+		if mode == ingestMerge {
+			// Families absent from the payload stay stored and use the budget too
+			for name, series := range h.store.IngestedSeries(instanceID) {
+				if _, ok := families[name]; !ok {
+					budget -= series
+				}
+			}
+		}
+
+		count := 0
+		for _, name := range names {
+			mf := families[name]
+			switch {
+			case budget >= len(mf.Metric):
+				budget -= len(mf.Metric)
+			case budget > 0:
+				count += len(mf.Metric) - budget
+				sortSeries(mf.Metric)
+				mf.Metric = mf.Metric[:budget]
+				budget = 0
+			default:
+				count += len(mf.Metric)
+				mf.Metric = nil
+			}
+		}
+
+		checker.drop(limitReasonSeries, count,
+			"instance '%s' exceeds %d series", instanceID, limits.MaxSeries)
+	}
+
+	if checker.first == nil {
+		return limitResult{}
+	}
+
+	if limits.Policy != config.LimitPolicyTruncate {
+		return limitResult{err: checker.first, total: total}
+	}
+
+	for _, name := range names {
+		if len(families[name].Metric) == 0 {
+			delete(families, name)
+		}
+	}
+
+	return limitResult{dropped: checker.dropped}
+}
+
+// hasLongLabelValue reports whether any label value of the series is longer than limit.
+func hasLongLabelValue(m *dto.Metric, limit int) bool {
+	for _, lp := range m.Label {
+		if len(lp.GetValue()) > limit {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortSeries orders series by their sorted label pairs.
+func sortSeries(metrics []*dto.Metric) {
+	slices.SortFunc(metrics, func(a, b *dto.Metric) int {
+		for i := 0; i < len(a.Label) && i < len(b.Label); i++ {
+			if c := strings.Compare(a.Label[i].GetName(), b.Label[i].GetName()); c != 0 {
+				return c
+			}
+			if c := strings.Compare(a.Label[i].GetValue(), b.Label[i].GetValue()); c != 0 {
+				return c
+			}
+		}
+
+		return len(a.Label) - len(b.Label)
+	})
+}
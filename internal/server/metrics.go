@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+// promLogger routes promhttp errors to the application logger.
+type promLogger struct{}
+
+// Println implements promhttp.Logger.
+func (promLogger) Println(v ...any) {
+	log.Error().Msg(fmt.Sprint(v...))
+}
+
+// MetricsHandler serves gathered metrics with promhttp, instrumented in reg.
+// With OpenMetrics negotiated (unless prometheus.disable_openmetrics is set) scrapers
+// receive "_created" series and exemplars preserved from ingest payloads.
+func (h *Handler) MetricsHandler(gatherer prometheus.Gatherer, reg prometheus.Registerer) http.Handler {
+	opts := promhttp.HandlerOpts{
+		ErrorLog:      promLogger{},
+		ErrorHandling: promhttp.ContinueOnError,
+		Registry:      reg,
+	}
+	text := promhttp.HandlerFor(gatherer, opts)
+
+	// Both handlers share the error counter, promhttp reuses an already registered one
+	opts.EnableOpenMetrics = true
+	opts.EnableOpenMetricsTextCreatedSamples = true
+	openMetrics := promhttp.HandlerFor(gatherer, opts)
+
+	return promhttp.InstrumentMetricHandler(reg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Negotiation may be switched off on config reload
+		if h.config().App.Prometheus.DisableOpenMetrics {
+			text.ServeHTTP(w, r)
+			return
+		}
+
+		openMetrics.ServeHTTP(w, r)
+	}))
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rs/zerolog/hlog"
+)
+
+// maxZstdWindow bounds memory a single zstd stream may ask the decoder to allocate.
+const maxZstdWindow = 32 << 20
+
+// bodyStatsKey is the context key for *bodyStats.
+type bodyStatsKey struct{}
+
+// bodyStats counts bytes of a compressed request body before and after decompression.
+type bodyStats struct {
+	wire    *countingReader
+	decoded *countingReader
+}
+
+// decodeError marks failures of the decompressor, as opposed to transport errors.
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string {
+	return "invalid compressed body: " + e.err.Error()
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
+
+// decodedBody reads the decompressed stream and closes decompressor and original body.
+type decodedBody struct {
+	reader   io.Reader
+	decoder  io.Closer
+	original io.Closer
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	if err != nil && err != io.EOF {
+		var maxErr *http.MaxBytesError
+		if !errors.As(err, &maxErr) {
+			err = &decodeError{err: err}
+		}
+	}
+
+	return n, err
+}
+
+func (b *decodedBody) Close() error {
+	_ = b.decoder.Close()
+	return b.original.Close()
+}
+
+// DecompressMiddleware transparently decodes gzip, deflate and zstd request bodies
+// according to the Content-Encoding header.
+// MaxBodySize limits the compressed body here, handlers apply the same limit
+// to the decompressed stream, which protects from decompression bombs.
+func (h *Handler) DecompressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if encoding == "" || encoding == "identity" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		wire := &countingReader{r: http.MaxBytesReader(w, r.Body, h.config().App.Ingest.MaxBodySize)}
+		decoder, err := newDecompressor(encoding, wire)
+		if err != nil {
+			hlog.FromRequest(r).Warn().
+				Err(err).
+				Str("encoding", encoding).
+				Msg("failed to decode request body")
+
+			if errors.Is(err, errUnsupportedEncoding) {
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			} else {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+
+			return
+		}
+
+		stats := &bodyStats{
+			wire:    wire,
+			decoded: &countingReader{r: decoder},
+		}
+
+		r.Body = &decodedBody{reader: stats.decoded, decoder: decoder, original: r.Body}
+		r.Header.Del("Content-Encoding")
+		r.ContentLength = -1
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bodyStatsKey{}, stats)))
+	})
+}
+
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// newDecompressor creates a decoder for the Content-Encoding value.
+func newDecompressor(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, &decodeError{err: err}
+		}
+		return zr, nil
+
+	case "deflate":
+		// HTTP deflate is zlib-wrapped, but many clients send raw deflate streams
+		br := bufio.NewReader(r)
+		header, err := br.Peek(2)
+		if err == nil && isZlibHeader(header) {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, &decodeError{err: err}
+			}
+			return zr, nil
+		}
+		return flate.NewReader(br), nil
+
+	case "zstd":
+		zr, err := zstd.NewReader(r,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true),
+			zstd.WithDecoderMaxWindow(maxZstdWindow))
+		if err != nil {
+			return nil, &decodeError{err: err}
+		}
+		return zr.IOReadCloser(), nil
+
+	default:
+		return nil, fmt.Errorf("%w %q", errUnsupportedEncoding, encoding)
+	}
+}
+
+// isZlibHeader checks the RFC 1950 header: deflate method and valid check bits.
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// recordBodyStats reports compressed and decompressed body sizes of an accepted request.
+func (h *Handler) recordBodyStats(r *http.Request, instanceID string) {
+	stats, ok := r.Context().Value(bodyStatsKey{}).(*bodyStats)
+	if !ok {
+		return
+	}
+
+	h.store.UpdateIngestEncoding(instanceID, stats.wire.count, stats.decoded.count)
+}
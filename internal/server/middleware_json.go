@@ -14,6 +14,8 @@ func (h *Handler) JSONTranslatorMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Query().Get("format") == "json" {
 			r.Body = newJSONToTextReader(r.Body)
+			// Translated body is text, Content-Type must not select another format
+			r.Header.Set("Content-Type", "text/plain")
 		}
 
 		next.ServeHTTP(w, r)
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/hlog"
+)
+
+// Request signing headers.
+const (
+	signatureTimestampHeader = "X-MetricZ-Timestamp"
+	signatureNonceHeader     = "X-MetricZ-Nonce"
+	signatureHeader          = "X-MetricZ-Signature"
+)
+
+// Nonce length bounds, nonces are stored in memory until they expire.
+const (
+	minNonceLength = 8
+	maxNonceLength = 128
+)
+
+var (
+	errSignatureMissing   = errors.New("request signature headers are missing")
+	errSignatureTimestamp = errors.New("request timestamp is invalid or outside allowed skew")
+	errSignatureNonce     = errors.New("request nonce is invalid")
+	errSignatureReplay    = errors.New("request nonce was already used")
+	errSignatureMismatch  = errors.New("request signature mismatch")
+)
+
+// nonceCache remembers nonces of verified requests until they can no longer pass the timestamp check.
+type nonceCache struct {
+	seen      map[string]time.Time
+	lastPrune time.Time
+	mu        sync.Mutex
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+// add stores the nonce until expiresAt and reports false if it is already known.
+func (c *nonceCache) add(key string, now, expiresAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastPrune) > time.Minute {
+		for k, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	if exp, ok := c.seen[key]; ok && !now.After(exp) {
+		return false
+	}
+
+	c.seen[key] = expiresAt
+	return true
+}
+
+// SignatureMiddleware verifies HMAC-SHA256 signed ingest requests of instances with ingest_auth.hmac_secret.
+//
+// The client sends X-MetricZ-Timestamp (unix seconds), X-MetricZ-Nonce and
+// X-MetricZ-Signature = hex(HMAC-SHA256(secret, method + "\n" + request URI + "\n" +
+// timestamp + "\n" + nonce + "\n" + hex(SHA256(body)))), where body is hashed as sent
+// (before decompression). Stale timestamps and reused nonces are rejected.
+func (h *Handler) SignatureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		instanceID := chi.URLParam(r, "instance_id")
+		srv := h.config().Server(instanceID)
+		if srv == nil || srv.IngestAuth == nil || srv.IngestAuth.HMACSecret == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.config().App.Ingest.MaxBodySize))
+		_ = r.Body.Close()
+		if err != nil {
+			if isBodyTooLarge(err) {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, "failed to read body", http.StatusBadRequest)
+			}
+
+			return
+		}
+
+		if err := h.verifySignature(r, instanceID, []byte(srv.IngestAuth.HMACSecret), body); err != nil {
+			hlog.FromRequest(r).Warn().
+				Err(err).
+				Str("instance_id", instanceID).
+				Msg("ingest signature verification failed")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifySignature checks timestamp skew, signature and nonce reuse, in this order,
+// so only requests with a valid signature occupy the nonce cache.
+func (h *Handler) verifySignature(r *http.Request, instanceID string, secret, body []byte) error {
+	timestamp := r.Header.Get(signatureTimestampHeader)
+	nonce := r.Header.Get(signatureNonceHeader)
+	signature := r.Header.Get(signatureHeader)
+	if timestamp == "" || nonce == "" || signature == "" {
+		return errSignatureMissing
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errSignatureTimestamp
+	}
+
+	now := time.Now()
+	skew := h.config().App.Ingest.SignatureMaxSkew.ToDuration()
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-skew)) || signedAt.After(now.Add(skew)) {
+		return errSignatureTimestamp
+	}
+
+	if len(nonce) < minNonceLength || len(nonce) > maxNonceLength {
+		return errSignatureNonce
+	}
+
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return errSignatureMismatch
+	}
+
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	_, _ = io.WriteString(mac, r.Method+"\n"+r.URL.RequestURI()+"\n"+timestamp+"\n"+nonce+"\n")
+	_, _ = io.WriteString(mac, hex.EncodeToString(bodyHash[:]))
+
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errSignatureMismatch
+	}
+
+	// Nonce must outlive the window in which its timestamp is still accepted
+	if !h.nonces.add(instanceID+"\x00"+nonce, now, signedAt.Add(skew)) {
+		return errSignatureReplay
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/woozymasta/metricz-exporter/internal/parser"
+	"github.com/woozymasta/metricz-exporter/internal/relabel"
+	"github.com/woozymasta/metricz-exporter/internal/storage"
+)
+
+// requestFormat selects the payload format, "format=influx" query selects InfluxDB line protocol
+// and "format=structured-json" structured JSON, otherwise the format is selected by Content-Type.
+func requestFormat(r *http.Request) parser.Format {
+	switch r.URL.Query().Get("format") {
+	case "influx":
+		return parser.FormatInflux
+	case "structured-json":
+		return parser.FormatJSON
+	}
+
+	return parser.FormatFromContentType(r.Header.Get("Content-Type"))
+}
+
+// processIngested runs parsed families through the server-side ingest pipeline:
+// global metric relabeling, relabeling of the instance ServerDefinition and cardinality limits.
+// It returns the number of series dropped by limits or *limitError if the payload is rejected.
+func (h *Handler) processIngested(instanceID string, mode ingestMode, families map[string]*dto.MetricFamily) (map[string]*dto.MetricFamily, int, error) {
+	families, limits := h.runPipeline(instanceID, mode, families)
+	limits.record(h.store, instanceID)
+	if limits.err != nil {
+		return nil, 0, limits.err
+	}
+
+	return families, limits.rejected(), nil
+}
+
+// runPipeline relabels families and applies limits without recording anything in storage.
+func (h *Handler) runPipeline(instanceID string, mode ingestMode, families map[string]*dto.MetricFamily) (map[string]*dto.MetricFamily, limitResult) {
+	// Tombstones address families by their ingested names, relabeling must not touch them
+	tombstones, hasTombstones := families[storage.TombstoneFamily]
+	delete(families, storage.TombstoneFamily)
+
+	cfg := h.config()
+	families = relabel.Process(families, cfg.App.Ingest.MetricRelabelConfigs)
+	if srv := cfg.Server(instanceID); srv != nil {
+		families = relabel.Process(families, srv.MetricRelabelConfigs)
+	}
+
+	limits := h.enforceLimits(instanceID, mode, families)
+	if hasTombstones && limits.err == nil {
+		families[storage.TombstoneFamily] = tombstones
+	}
+
+	return families, limits
+}
+
+// writeIngestOK confirms an applied payload, series dropped by limits are reported in header and body.
+func writeIngestOK(w http.ResponseWriter, rejected int) {
+	w.Header().Set("Content-Type", "text/plain")
+	if rejected == 0 {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("OK"))
+		return
+	}
+
+	w.Header().Set(rejectedSeriesHeader, strconv.Itoa(rejected))
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintf(w, "OK, %d series rejected by ingest limits", rejected)
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/hlog"
+	"github.com/woozymasta/metricz-exporter/internal/config"
+	"golang.org/x/time/rate"
+)
+
+// limiterIdleTTL is how long an unused token bucket is kept, a refilled bucket carries no state.
+const limiterIdleTTL = 10 * time.Minute
+
+// keyedLimiter holds a token bucket per key (instance ID or client IP).
+type keyedLimiter struct {
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	limit     rate.Limit
+	burst     int
+	mu        sync.Mutex
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newKeyedLimiter returns nil if the limit is disabled.
+func newKeyedLimiter(cfg config.RateLimitConfig) *keyedLimiter {
+	if cfg.Rate <= 0 {
+		return nil
+	}
+
+	return &keyedLimiter{
+		buckets: make(map[string]*bucket),
+		limit:   rate.Limit(cfg.Rate),
+		burst:   cfg.Burst,
+	}
+}
+
+// allow takes a token for the key, if the bucket is empty it returns false
+// and the time until the next token is available.
+func (l *keyedLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > limiterIdleTTL {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// ingestLimits throttles requests that parse payloads.
+type ingestLimits struct {
+	perInstance  *keyedLimiter
+	perIP        *keyedLimiter
+	parseSlots   chan struct{}
+	queueTimeout time.Duration
+}
+
+func newIngestLimits(cfg config.IngestRateLimitConfig) *ingestLimits {
+	slots := cfg.MaxConcurrentParses
+	if slots <= 0 {
+		slots = runtime.NumCPU()
+	}
+
+	return &ingestLimits{
+		perInstance:  newKeyedLimiter(cfg.PerInstance),
+		perIP:        newKeyedLimiter(cfg.PerIP),
+		parseSlots:   make(chan struct{}, slots),
+		queueTimeout: cfg.ParseQueueTimeout.ToDuration(),
+	}
+}
+
+// RateLimitMiddleware rejects requests over the per-instance or per-IP rate with 429,
+// it runs before the body is read, verified or decompressed.
+func (h *Handler) RateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		instanceID := chi.URLParam(r, "instance_id")
+		ingestLimits := h.limits.Load()
+		now := time.Now()
+
+		limits := []struct {
+			limiter *keyedLimiter
+			key     string
+			scope   string
+		}{
+			{ingestLimits.perIP, clientIP(r), "ip"},
+			{ingestLimits.perInstance, instanceID, "instance"},
+		}
+
+		for _, limit := range limits {
+			if limit.limiter == nil {
+				continue
+			}
+
+			if ok, wait := limit.limiter.allow(limit.key, now); !ok {
+				hlog.FromRequest(r).Warn().
+					Str("instance_id", instanceID).
+					Str("scope", limit.scope).
+					Dur("retry_after", wait).
+					Msg("ingest rate limit exceeded")
+				setRetryAfter(w, wait)
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquireParseSlot waits for a free parse slot, so a client looping on failed uploads can not
+// occupy every CPU with parsing. The payload must be buffered already, slow senders must not
+// hold slots. Without a free slot in time it responds with 503 and returns false,
+// otherwise release must be called once parsing is done.
+func (h *Handler) acquireParseSlot(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	ingestLimits := h.limits.Load()
+
+	timer := time.NewTimer(ingestLimits.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case ingestLimits.parseSlots <- struct{}{}:
+		return func() { <-ingestLimits.parseSlots }, true
+
+	case <-timer.C:
+		hlog.FromRequest(r).Warn().
+			Str("instance_id", chi.URLParam(r, "instance_id")).
+			Msg("no free parse slot, ingest request rejected")
+		setRetryAfter(w, time.Second)
+		http.Error(w, "server busy", http.StatusServiceUnavailable)
+
+		return nil, false
+
+	case <-r.Context().Done():
+		return nil, false
+	}
+}
+
+// clientIP returns the request IP without port, RemoteAddr is already rewritten by RealIP middleware.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, at least one.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := max(int(math.Ceil(wait.Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
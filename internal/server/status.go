@@ -29,13 +29,14 @@ func (h *Handler) HandleStatusFull(w http.ResponseWriter, _ *http.Request) {
 }
 
 func (h *Handler) serveStatusRequest(w http.ResponseWriter, cacheKey string, isAll bool) {
-	if !h.cfg.App.Public.Enabled {
+	cfg := h.config()
+	if !cfg.App.Public.Enabled {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
 
 	// CORS
-	if h.cfg.App.Public.PublicCORS {
+	if cfg.App.Public.PublicCORS {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
 	}
@@ -61,7 +62,7 @@ func (h *Handler) serveStatusRequest(w http.ResponseWriter, cacheKey string, isA
 		resp := make(map[string]*publicStatusData)
 		for id, state := range states {
 			// Reuse the collector logic
-			resp[id] = collectPublicData(&state, h.cfg.PublicExport)
+			resp[id] = collectPublicData(&state, cfg.PublicExport, cfg.App.Stale.FamilyMaxAge.ToDuration())
 		}
 		body, err = json.Marshal(resp)
 	} else {
@@ -70,7 +71,7 @@ func (h *Handler) serveStatusRequest(w http.ResponseWriter, cacheKey string, isA
 			http.Error(w, "Instance not found", http.StatusNotFound)
 			return
 		}
-		data := collectPublicData(&state, h.cfg.PublicExport)
+		data := collectPublicData(&state, cfg.PublicExport, cfg.App.Stale.FamilyMaxAge.ToDuration())
 		body, err = json.Marshal(data)
 	}
 
@@ -82,7 +83,7 @@ func (h *Handler) serveStatusRequest(w http.ResponseWriter, cacheKey string, isA
 	// Store in Cache
 	h.publicCache.Store(cacheKey, &publicCacheItem{
 		response:  body,
-		expiresAt: time.Now().Add(h.cfg.App.Public.PublicCacheTTL.ToDuration()),
+		expiresAt: time.Now().Add(cfg.App.Public.PublicCacheTTL.ToDuration()),
 	})
 
 	w.Header().Set("Content-Type", "application/json")
@@ -91,7 +92,7 @@ func (h *Handler) serveStatusRequest(w http.ResponseWriter, cacheKey string, isA
 }
 
 // collectPublicData helper transforms internal state to clean JSON structure based on config allow-list
-func collectPublicData(state *storage.InstanceState, cfg config.PublicExportConfig) *publicStatusData {
+func collectPublicData(state *storage.InstanceState, cfg config.PublicExportConfig, familyMaxAge time.Duration) *publicStatusData {
 	out := &publicStatusData{
 		Values: make(map[string]float64),
 		Labels: make(map[string]map[string][]string),
@@ -116,18 +117,7 @@ func collectPublicData(state *storage.InstanceState, cfg config.PublicExportConf
 
 			// Values: sum across all samples in the family
 			if wantValues[name] {
-				var sum float64
-				for _, m := range mf.Metric {
-					if m == nil {
-						continue
-					}
-					if m.Gauge != nil {
-						sum += m.Gauge.GetValue()
-					} else if m.Counter != nil {
-						sum += m.Counter.GetValue()
-					}
-				}
-				out.Values[name] = sum
+				aggregateValues(out.Values, mf)
 			}
 
 			// Labels: collect unique values per label key across all samples
@@ -182,7 +172,7 @@ func collectPublicData(state *storage.InstanceState, cfg config.PublicExportConf
 
 	// Ingested (Using cached status if needed, otherwise raw)
 	if state.IngestedFamilies != nil {
-		processFamilies(state.IngestedFamilies)
+		processFamilies(state.ActiveIngestedFamilies(time.Now(), familyMaxAge))
 	} else if state.CachedStatusFamily != nil {
 		tmp := map[string]*dto.MetricFamily{
 			state.CachedStatusFamily.GetName(): state.CachedStatusFamily,
@@ -193,3 +183,50 @@ func collectPublicData(state *storage.InstanceState, cfg config.PublicExportConf
 
 	return out
 }
+
+// aggregateValues sums all samples of the family into values.
+// Gauge, counter and untyped samples are summed under the family name,
+// histograms and summaries are summed into "<name>_sum" and "<name>_count".
+func aggregateValues(values map[string]float64, mf *dto.MetricFamily) {
+	name := mf.GetName()
+
+	switch mf.GetType() {
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM, dto.MetricType_SUMMARY:
+		var sum, count float64
+		for _, m := range mf.Metric {
+			if m == nil {
+				continue
+			}
+			if m.Histogram != nil {
+				sum += m.Histogram.GetSampleSum()
+				if m.Histogram.SampleCountFloat != nil {
+					count += m.Histogram.GetSampleCountFloat()
+				} else {
+					count += float64(m.Histogram.GetSampleCount())
+				}
+			} else if m.Summary != nil {
+				sum += m.Summary.GetSampleSum()
+				count += float64(m.Summary.GetSampleCount())
+			}
+		}
+		values[name+"_sum"] = sum
+		values[name+"_count"] = count
+
+	default:
+		var sum float64
+		for _, m := range mf.Metric {
+			if m == nil {
+				continue
+			}
+			switch {
+			case m.Gauge != nil:
+				sum += m.Gauge.GetValue()
+			case m.Counter != nil:
+				sum += m.Counter.GetValue()
+			case m.Untyped != nil:
+				sum += m.Untyped.GetValue()
+			}
+		}
+		values[name] = sum
+	}
+}
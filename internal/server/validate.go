@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog/hlog"
+	"github.com/woozymasta/metricz-exporter/internal/parser"
+)
+
+// Stages of the ingest pipeline reported by ValidationError.
+const (
+	validationStageParse  = "parse"
+	validationStageLimits = "limits"
+)
+
+// ValidationReport is the result of a dry-run ingest, nothing is stored.
+type ValidationReport struct {
+	// Error is set if the payload would be refused.
+	Error *ValidationError `json:"error,omitempty"`
+
+	// Duplicates counts series collapsed by "Last Write Wins" by family name.
+	Duplicates map[string]int `json:"duplicates,omitempty"`
+
+	// Rejected counts series the truncate limits policy would drop by reason.
+	Rejected map[string]int `json:"rejected_series,omitempty"`
+
+	InstanceID string `json:"instance_id"`
+	Format     string `json:"format"`
+	Mode       string `json:"mode"`
+
+	// Families are the families that would be stored, after relabeling and limits.
+	Families []FamilyReport `json:"families"`
+
+	// ParsedSeries is the number of series in the payload before deduplication.
+	ParsedSeries int `json:"parsed_series"`
+
+	// Series is the number of series that would be stored.
+	Series int  `json:"series"`
+	Valid  bool `json:"valid"`
+}
+
+// ValidationError describes why a payload would be refused.
+type ValidationError struct {
+	Stage   string `json:"stage"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+
+	// Path locates the invalid value of a structured JSON payload.
+	Path string `json:"path,omitempty"`
+
+	// Line locates syntax errors (1-based), for "format=json" payloads it is the array element.
+	Line int `json:"line,omitempty"`
+
+	// Column is set for OpenMetrics and Influx payloads only,
+	// the Prometheus text parser reports lines without a position within them.
+	Column int `json:"column,omitempty"`
+}
+
+// FamilyReport describes a metric family of ValidationReport.
+type FamilyReport struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Series int    `json:"series"`
+}
+
+// handleValidate runs the ingest pipeline over the payload without storing it
+// and responds with ValidationReport, 200 if the payload would be accepted and 422 otherwise.
+func (h *Handler) handleValidate(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instance_id")
+
+	mode, err := ingestModeFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Body is buffered before a parse slot is taken, slow senders must not hold slots
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.config().App.Ingest.MaxBodySize))
+	_ = r.Body.Close()
+
+	var report *ValidationReport
+	format := requestFormat(r)
+	if err != nil {
+		report = h.validate(&failingReader{err: err}, instanceID, format, mode)
+	} else {
+		release, ok := h.acquireParseSlot(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+
+		report = h.validate(bytes.NewReader(body), instanceID, format, mode)
+	}
+
+	hlog.FromRequest(r).Debug().
+		Str("instance_id", instanceID).
+		Bool("valid", report.Valid).
+		Int("series", report.Series).
+		Msg("payload validated")
+
+	status := http.StatusOK
+	if !report.Valid {
+		status = http.StatusUnprocessableEntity
+	}
+
+	writeJSON(w, status, report)
+}
+
+// failingReader returns err on every read, it reports a failed body read as a parse error.
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// ValidatePayload checks a payload like ingest does, for the --validate-file flag.
+// jsonLines marks a JSON array of exposition lines (format=json of ingest endpoints).
+// With an empty instanceID instance_id labels are not checked and no server rules apply.
+func (h *Handler) ValidatePayload(body io.ReadCloser, instanceID string, format parser.Format, jsonLines bool) *ValidationReport {
+	if jsonLines {
+		body = newJSONToTextReader(body)
+	}
+
+	return h.validate(body, instanceID, format, ingestReplace)
+}
+
+// validate runs parsing, relabeling and limits over the payload, storage is only read.
+func (h *Handler) validate(body io.Reader, instanceID string, format parser.Format, mode ingestMode) *ValidationReport {
+	report := &ValidationReport{
+		InstanceID: instanceID,
+		Format:     format.String(),
+		Mode:       mode.String(),
+		Families:   []FamilyReport{},
+	}
+
+	overwrite := h.config().App.Ingest.OverwriteInstanceID || instanceID == ""
+	families, stats, err := parser.ParseWithStats(body, format, instanceID, overwrite)
+	report.ParsedSeries = stats.Series
+	if len(stats.Duplicates) > 0 {
+		report.Duplicates = stats.Duplicates
+	}
+	if err != nil {
+		report.Error = newParseValidationError(err)
+		return report
+	}
+
+	families, limits := h.runPipeline(instanceID, mode, families)
+	if limits.err != nil {
+		report.Error = &ValidationError{
+			Stage:   validationStageLimits,
+			Reason:  limits.err.reason,
+			Message: limits.err.Error(),
+		}
+
+		return report
+	}
+	if len(limits.dropped) > 0 {
+		report.Rejected = limits.dropped
+	}
+
+	for name, mf := range families {
+		report.Families = append(report.Families, FamilyReport{
+			Name:   name,
+			Type:   mf.GetType().String(),
+			Series: len(mf.Metric),
+		})
+		report.Series += len(mf.Metric)
+	}
+
+	sort.Slice(report.Families, func(i, j int) bool {
+		return report.Families[i].Name < report.Families[j].Name
+	})
+	report.Valid = true
+
+	return report
+}
+
+// newParseValidationError classifies a parser error and extracts its position.
+func newParseValidationError(err error) *ValidationError {
+	vErr := &ValidationError{
+		Stage:   validationStageParse,
+		Reason:  parseErrorReason(err),
+		Message: err.Error(),
+	}
+
+	var synErr *parser.SyntaxError
+	var textErr expfmt.ParseError
+	var jsonErr *parser.JSONError
+	switch {
+	case errors.As(err, &jsonErr):
+		vErr.Path = jsonErr.Path
+	case errors.As(err, &synErr):
+		vErr.Line, vErr.Column = synErr.Line, synErr.Column
+	case errors.As(err, &textErr):
+		// expfmt does not track the position within the line
+		vErr.Line = textErr.Line
+	}
+
+	return vErr
+}
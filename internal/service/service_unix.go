@@ -3,6 +3,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"os"
 )
@@ -11,7 +12,7 @@ import (
 func IsRunningUnderWindowsService() bool { return false }
 
 // RunUnderWindowsService is unsupported on non-Windows platforms.
-func RunUnderWindowsService(_ func()) {
+func RunUnderWindowsService(_ func(ctx context.Context)) {
 	fmt.Fprintln(os.Stderr, "Windows SCM is not supported on this platform")
 	os.Exit(1)
 }
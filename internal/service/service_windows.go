@@ -3,36 +3,57 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"golang.org/x/sys/windows/svc"
 )
 
+// stopWaitHint is reported to SCM while the app shuts down.
+const stopWaitHint = 30 * time.Second
+
 // serviceHandler is an SCM handler that starts the app in a goroutine and
-// acknowledges Stop/Shutdown. The app must handle its own graceful shutdown.
-type serviceHandler struct{ run func() }
+// cancels its context on Stop/Shutdown, the app handles graceful shutdown itself.
+type serviceHandler struct{ run func(ctx context.Context) }
 
 // Execute implements svc.Handler. It starts run() and reports Running.
-// On Stop/Shutdown it reports StopPending and returns.
+// On Stop/Shutdown it reports StopPending, cancels the context and returns after run() is done.
 func (h *serviceHandler) Execute(_ []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
 	s <- svc.Status{State: svc.StartPending}
-	go h.run()
-	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
 
-	for c := range r {
-		switch c.Cmd {
-		case svc.Interrogate:
-			s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.run(ctx)
+	}()
+
+	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
 
-		case svc.Stop, svc.Shutdown:
-			s <- svc.Status{State: svc.StopPending}
+	for {
+		select {
+		case <-done:
+			// The app stopped by itself (e.g. failed to start)
 			return false, 0
+
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+			case svc.Stop, svc.Shutdown:
+				s <- svc.Status{State: svc.StopPending, WaitHint: uint32(stopWaitHint.Milliseconds())}
+				cancel()
+				<-done
+				return false, 0
+			}
 		}
 	}
-
-	return false, 0
 }
 
 // IsRunningUnderWindowsService reports whether the current process is managed by
@@ -53,8 +74,8 @@ func defaultServiceName() string {
 }
 
 // RunUnderWindowsService runs the provided function under SCM.
-// The function must block until the app is done.
-func RunUnderWindowsService(run func()) {
+// The function must block until the app is done or ctx is canceled on service stop.
+func RunUnderWindowsService(run func(ctx context.Context)) {
 	if err := svc.Run(defaultServiceName(), &serviceHandler{run: run}); err != nil {
 		fmt.Fprintf(os.Stderr, "Service failed: %v\n", err)
 		os.Exit(1)
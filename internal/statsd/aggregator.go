@@ -0,0 +1,338 @@
+package statsd
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/woozymasta/metricz-exporter/internal/parser"
+)
+
+// Reasons of dropped samples, used as "reason" label values.
+const (
+	dropInvalid         = "invalid"
+	dropUnsupported     = "unsupported"
+	dropMissingInstance = "missing_instance"
+	dropForbidden       = "forbidden"
+	dropTypeConflict    = "type_conflict"
+	dropSeriesLimit     = "series_limit"
+)
+
+var (
+	errTypeConflict = errors.New("family already has another type")
+	errSeriesLimit  = errors.New("instance series limit reached")
+)
+
+// reservedLabels are set by the exporter or have special meaning for Prometheus.
+var reservedLabels = map[string]bool{"instance_id": true, "le": true, "quantile": true}
+
+// family aggregates series of one metric name, all of the same StatsD type.
+type family struct {
+	series map[string]*series
+	name   string
+	kind   string
+}
+
+// series holds the aggregated state of one label set.
+type series struct {
+	lastSeen time.Time
+	set      map[string]struct{}
+	labels   []*dto.LabelPair
+	buckets  []float64
+	value    float64
+	count    float64
+	sum      float64
+}
+
+// instance holds families of one instance.
+type instance struct {
+	lastSeen time.Time
+	families map[string]*family
+	series   int
+}
+
+// flushed holds families of one instance built by flush and the time of its last sample.
+type flushed struct {
+	lastSeen time.Time
+	families map[string]*dto.MetricFamily
+}
+
+// aggregator accumulates samples between flushes, counters and histograms are cumulative,
+// gauges keep the last value and sets count unique values seen during the last interval.
+type aggregator struct {
+	instances map[string]*instance
+	prefix    string
+	buckets   []float64
+	maxAge    time.Duration
+	maxSeries int
+}
+
+func newAggregator(prefix string, buckets []float64, maxAge time.Duration, maxSeries int) *aggregator {
+	return &aggregator{
+		instances: make(map[string]*instance),
+		prefix:    prefix,
+		buckets:   buckets,
+		maxAge:    maxAge,
+		maxSeries: maxSeries,
+	}
+}
+
+// familyName returns the Prometheus family name of a StatsD metric.
+func (a *aggregator) familyName(name, kind string) string {
+	name = parser.SanitizeName(a.prefix + name)
+
+	switch kind {
+	case typeCounter:
+		if !strings.HasSuffix(name, "_total") {
+			name += "_total"
+		}
+	case typeTimer:
+		if !strings.HasSuffix(name, "_seconds") {
+			name += "_seconds"
+		}
+	}
+
+	return name
+}
+
+// add applies every value of the sample to the series of the instance.
+func (a *aggregator) add(instanceID string, s *sample, labels []*dto.LabelPair, now time.Time) error {
+	// Values are parsed first, an invalid one must not leave an empty series behind
+	var values []float64
+	var relative []bool
+	if s.kind != typeSet {
+		values = make([]float64, len(s.values))
+		relative = make([]bool, len(s.values))
+		for i, raw := range s.values {
+			var err error
+			if values[i], relative[i], err = parseValue(s.kind, raw); err != nil {
+				return err
+			}
+		}
+	}
+
+	inst, ok := a.instances[instanceID]
+	if !ok {
+		inst = &instance{families: make(map[string]*family)}
+		a.instances[instanceID] = inst
+	}
+
+	name := a.familyName(s.name, s.kind)
+	fam, ok := inst.families[name]
+	if !ok {
+		fam = &family{name: name, kind: s.kind, series: make(map[string]*series)}
+		inst.families[name] = fam
+	}
+	if !sameKind(fam.kind, s.kind) {
+		return errTypeConflict
+	}
+
+	key := seriesKey(labels)
+	ser, ok := fam.series[key]
+	if !ok {
+		if a.maxSeries > 0 && inst.series >= a.maxSeries {
+			return errSeriesLimit
+		}
+
+		ser = &series{labels: labels}
+		if isHistogram(s.kind) {
+			ser.buckets = make([]float64, len(a.buckets))
+		}
+		fam.series[key] = ser
+		inst.series++
+	}
+	ser.lastSeen = now
+	inst.lastSeen = now
+
+	if s.kind == typeSet {
+		if ser.set == nil {
+			ser.set = make(map[string]struct{})
+		}
+		for _, raw := range s.values {
+			ser.set[raw] = struct{}{}
+		}
+
+		return nil
+	}
+
+	for i, value := range values {
+		switch s.kind {
+		case typeCounter:
+			ser.value += value / s.rate
+
+		case typeGauge:
+			if relative[i] {
+				ser.value += value
+			} else {
+				ser.value = value
+			}
+
+		default:
+			if s.kind == typeTimer {
+				value /= 1000
+			}
+			a.observe(ser, value, 1/s.rate)
+		}
+	}
+
+	return nil
+}
+
+// observe adds a weighted observation to histogram series.
+func (a *aggregator) observe(ser *series, value, weight float64) {
+	ser.count += weight
+	ser.sum += value * weight
+
+	for i, bound := range a.buckets {
+		if value <= bound {
+			ser.buckets[i] += weight
+			break
+		}
+	}
+}
+
+// flush returns families of every instance and resets sets,
+// series not updated within maxAge are dropped first.
+// An instance without series left gets nil families so its previous state is cleared.
+func (a *aggregator) flush(now time.Time) map[string]flushed {
+	result := make(map[string]flushed, len(a.instances))
+
+	for instanceID, inst := range a.instances {
+		families := make(map[string]*dto.MetricFamily, len(inst.families))
+
+		for name, fam := range inst.families {
+			for key, ser := range fam.series {
+				if a.maxAge > 0 && now.Sub(ser.lastSeen) > a.maxAge {
+					delete(fam.series, key)
+					inst.series--
+				}
+			}
+			if len(fam.series) == 0 {
+				delete(inst.families, name)
+				continue
+			}
+
+			families[name] = a.buildFamily(fam)
+		}
+
+		if len(inst.families) == 0 {
+			delete(a.instances, instanceID)
+			result[instanceID] = flushed{lastSeen: inst.lastSeen}
+			continue
+		}
+
+		result[instanceID] = flushed{lastSeen: inst.lastSeen, families: families}
+	}
+
+	return result
+}
+
+// buildFamily converts the aggregated family into a new client model family.
+func (a *aggregator) buildFamily(fam *family) *dto.MetricFamily {
+	name := fam.name
+	mf := &dto.MetricFamily{Name: &name}
+
+	var typ dto.MetricType
+	switch fam.kind {
+	case typeCounter:
+		typ = dto.MetricType_COUNTER
+		mf.Help = help("StatsD counter.")
+	case typeGauge:
+		typ = dto.MetricType_GAUGE
+		mf.Help = help("StatsD gauge.")
+	case typeSet:
+		typ = dto.MetricType_GAUGE
+		mf.Help = help("Unique values of StatsD set during the last flush interval.")
+	case typeTimer:
+		typ = dto.MetricType_HISTOGRAM
+		mf.Help = help("StatsD timer in seconds.")
+	default:
+		typ = dto.MetricType_HISTOGRAM
+		mf.Help = help("StatsD histogram.")
+	}
+	mf.Type = &typ
+
+	keys := make([]string, 0, len(fam.series))
+	for key := range fam.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		ser := fam.series[key]
+		m := &dto.Metric{Label: ser.labels}
+
+		switch fam.kind {
+		case typeCounter:
+			value := ser.value
+			m.Counter = &dto.Counter{Value: &value}
+
+		case typeGauge:
+			value := ser.value
+			m.Gauge = &dto.Gauge{Value: &value}
+
+		case typeSet:
+			value := float64(len(ser.set))
+			m.Gauge = &dto.Gauge{Value: &value}
+			ser.set = nil
+
+		default:
+			m.Histogram = a.buildHistogram(ser)
+		}
+
+		mf.Metric = append(mf.Metric, m)
+	}
+
+	return mf
+}
+
+// buildHistogram converts weighted histogram counts into cumulative integer buckets.
+func (a *aggregator) buildHistogram(ser *series) *dto.Histogram {
+	count := uint64(math.Round(ser.count))
+	sum := ser.sum
+	h := &dto.Histogram{SampleCount: &count, SampleSum: &sum}
+
+	var cumulative float64
+	for i := range a.buckets {
+		bound := a.buckets[i]
+		cumulative += ser.buckets[i]
+		bucketCount := min(uint64(math.Round(cumulative)), count)
+		h.Bucket = append(h.Bucket, &dto.Bucket{UpperBound: &bound, CumulativeCount: &bucketCount})
+	}
+
+	return h
+}
+
+// sameKind reports whether samples of kind may update a family created by another kind.
+// Histograms and distributions are the same for Prometheus, timers are kept apart by the "_seconds" suffix.
+func sameKind(familyKind, kind string) bool {
+	if familyKind == kind {
+		return true
+	}
+
+	return isHistogram(familyKind) && isHistogram(kind) && familyKind != typeTimer && kind != typeTimer
+}
+
+func isHistogram(kind string) bool {
+	return kind == typeTimer || kind == typeHistogram || kind == typeDistribution
+}
+
+// seriesKey identifies a label set, labels must be sorted by name.
+func seriesKey(labels []*dto.LabelPair) string {
+	var b strings.Builder
+	for _, lp := range labels {
+		b.WriteString(lp.GetName())
+		b.WriteByte(0xff)
+		b.WriteString(lp.GetValue())
+		b.WriteByte(0xff)
+	}
+
+	return b.String()
+}
+
+func help(text string) *string {
+	return &text
+}
@@ -0,0 +1,107 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Metric types of StatsD samples.
+const (
+	typeCounter      = "c"
+	typeGauge        = "g"
+	typeTimer        = "ms"
+	typeHistogram    = "h"
+	typeDistribution = "d"
+	typeSet          = "s"
+)
+
+// errUnsupported marks DogStatsD events and service checks, they carry no metric values.
+var errUnsupported = errors.New("unsupported datagram")
+
+// sample is one parsed StatsD line, possibly with several values ("name:1:2|c").
+type sample struct {
+	name   string
+	kind   string
+	values []string
+	tags   []tag
+	rate   float64
+}
+
+// tag is a DogStatsD tag, value-less tags have an empty value.
+type tag struct {
+	key   string
+	value string
+}
+
+// parseLine parses "<name>:<value>[:<value>...]|<type>[|@<rate>][|#<tag>[:<value>],...]".
+// Unknown DogStatsD extension fields (container ID, timestamp) are ignored.
+func parseLine(line string) (*sample, error) {
+	if strings.HasPrefix(line, "_e{") || strings.HasPrefix(line, "_sc|") {
+		return nil, errUnsupported
+	}
+
+	fields := strings.Split(line, "|")
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("missing metric type in %q", line)
+	}
+
+	name, rawValues, ok := strings.Cut(fields[0], ":")
+	if !ok || name == "" || rawValues == "" {
+		return nil, fmt.Errorf("invalid metric %q", fields[0])
+	}
+
+	s := &sample{
+		name:   name,
+		kind:   fields[1],
+		values: strings.Split(rawValues, ":"),
+		rate:   1,
+	}
+
+	switch s.kind {
+	case typeCounter, typeGauge, typeTimer, typeHistogram, typeDistribution, typeSet:
+	default:
+		return nil, fmt.Errorf("unknown metric type %q", s.kind)
+	}
+
+	for _, field := range fields[2:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			rate, err := strconv.ParseFloat(field[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid sample rate %q", field)
+			}
+			s.rate = rate
+
+		case strings.HasPrefix(field, "#"):
+			for raw := range strings.SplitSeq(field[1:], ",") {
+				if raw == "" {
+					continue
+				}
+				key, value, _ := strings.Cut(raw, ":")
+				s.tags = append(s.tags, tag{key: key, value: value})
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// parseValue parses a numeric sample value, for gauges a leading sign marks a relative change.
+func parseValue(kind, raw string) (value float64, relative bool, err error) {
+	value, err = strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid value %q", raw)
+	}
+
+	// Counters are cumulative, a negative or non-finite increment would break them for good
+	if kind == typeCounter && (value < 0 || math.IsNaN(value) || math.IsInf(value, 0)) {
+		return 0, false, fmt.Errorf("invalid counter increment %q", raw)
+	}
+
+	relative = kind == typeGauge && (raw[0] == '+' || raw[0] == '-')
+
+	return value, relative, nil
+}
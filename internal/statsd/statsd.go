@@ -0,0 +1,265 @@
+// Package statsd receives StatsD/DogStatsD samples over UDP and aggregates them into instance state.
+package statsd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+	"github.com/woozymasta/metricz-exporter/internal/config"
+	"github.com/woozymasta/metricz-exporter/internal/parser"
+	"github.com/woozymasta/metricz-exporter/internal/storage"
+)
+
+// maxPacketSize is the largest UDP datagram payload.
+const maxPacketSize = 65535
+
+// Listener is a StatsD/DogStatsD UDP server. Samples must be tagged with the instance ID,
+// other tags become labels. Aggregated families are published to storage every flush interval.
+type Listener struct {
+	conn    net.PacketConn
+	store   *storage.Storage
+	cfg     atomic.Pointer[config.Config]
+	agg     *aggregator
+	packets prometheus.Counter
+	samples *prometheus.CounterVec
+	dropped *prometheus.CounterVec
+	mu      sync.Mutex
+}
+
+// Listen opens the UDP socket of statsd.listen_addr.
+func Listen(store *storage.Storage, cfg *config.Config) (*Listener, error) {
+	conn, err := net.ListenPacket("udp", cfg.App.StatsD.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	statsdCfg := cfg.App.StatsD
+
+	l := &Listener{
+		conn:  conn,
+		store: store,
+		agg: newAggregator(
+			statsdCfg.Prefix,
+			statsdCfg.Buckets,
+			statsdCfg.SeriesMaxAge.ToDuration(),
+			statsdCfg.MaxSeries),
+		packets: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "metricz_statsd_packets_total",
+			Help: "Total UDP packets received by the StatsD listener.",
+		}),
+		samples: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "metricz_statsd_samples_total",
+			Help: "Total StatsD samples aggregated for the instance.",
+		}, []string{"instance_id"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "metricz_statsd_dropped_samples_total",
+			Help: "Total StatsD samples dropped before aggregation.",
+		}, []string{"reason"}),
+	}
+	l.cfg.Store(cfg)
+
+	return l, nil
+}
+
+// UpdateConfig swaps the configuration on reload, the instance tag and allowed instances
+// apply to next samples. Listen address and aggregation settings require a restart.
+func (l *Listener) UpdateConfig(cfg *config.Config) {
+	l.cfg.Store(cfg)
+}
+
+// Describe implements prometheus.Collector.
+func (l *Listener) Describe(ch chan<- *prometheus.Desc) {
+	l.packets.Describe(ch)
+	l.samples.Describe(ch)
+	l.dropped.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (l *Listener) Collect(ch chan<- prometheus.Metric) {
+	l.packets.Collect(ch)
+	l.samples.Collect(ch)
+	l.dropped.Collect(ch)
+}
+
+// ForgetInstance drops aggregated series and sample counter of an evicted or deleted instance,
+// so its stale series do not bring the instance back on the next flush.
+func (l *Listener) ForgetInstance(instanceID string) {
+	l.mu.Lock()
+	delete(l.agg.instances, instanceID)
+	l.mu.Unlock()
+
+	l.samples.DeletePartialMatch(prometheus.Labels{"instance_id": instanceID})
+}
+
+// Serve reads packets and flushes aggregated samples until ctx is canceled.
+// Before it returns, the flusher is stopped and samples aggregated since the last tick
+// are flushed, so storage is complete and idle for the final snapshot of the caller.
+func (l *Listener) Serve(ctx context.Context) {
+	flushInterval := l.cfg.Load().App.StatsD.FlushInterval.ToDuration()
+
+	log.Info().
+		Str("address", l.conn.LocalAddr().String()).
+		Dur("flush_interval", flushInterval).
+		Msg("starting StatsD listener")
+
+	flushCtx, cancel := context.WithCancel(ctx)
+	var flusher sync.WaitGroup
+	flusher.Go(func() { l.runFlusher(flushCtx, flushInterval) })
+	defer func() {
+		cancel()
+		flusher.Wait()
+		l.flush(time.Now())
+	}()
+
+	go func() {
+		<-ctx.Done()
+		_ = l.conn.Close()
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				log.Debug().Msg("stopping StatsD listener")
+				return
+			}
+
+			log.Warn().Err(err).Msg("failed to read StatsD packet")
+			continue
+		}
+
+		l.packets.Inc()
+		l.handlePacket(string(buf[:n]), time.Now())
+	}
+}
+
+// runFlusher flushes aggregated samples every interval until ctx is canceled.
+func (l *Listener) runFlusher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-ticker.C:
+			l.flush(now)
+		}
+	}
+}
+
+// flush publishes aggregated families of every instance to storage.
+func (l *Listener) flush(now time.Time) {
+	l.mu.Lock()
+	states := l.agg.flush(now)
+	l.mu.Unlock()
+
+	for instanceID, state := range states {
+		l.store.UpdateStatsD(instanceID, state.families, state.lastSeen)
+	}
+}
+
+// handlePacket aggregates every newline separated sample of the packet.
+func (l *Listener) handlePacket(packet string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for line := range strings.SplitSeq(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if reason, err := l.handleLine(line, now); err != nil {
+			l.dropped.WithLabelValues(reason).Inc()
+			log.Trace().Err(err).Str("line", line).Str("reason", reason).Msg("StatsD sample dropped")
+		}
+	}
+}
+
+// handleLine aggregates one sample, on failure it returns the drop reason.
+// Must be called under mu.
+func (l *Listener) handleLine(line string, now time.Time) (string, error) {
+	s, err := parseLine(line)
+	if err != nil {
+		if errors.Is(err, errUnsupported) {
+			return dropUnsupported, err
+		}
+		return dropInvalid, err
+	}
+
+	cfg := l.cfg.Load()
+	instanceID, labels := tagLabels(s.tags, cfg.App.StatsD.InstanceTag)
+	if instanceID == "" {
+		return dropMissingInstance, errors.New("sample has no instance tag")
+	}
+	if !cfg.IngestAllowed(instanceID) {
+		return dropForbidden, errors.New("instance is not allowed")
+	}
+
+	if err := l.agg.add(instanceID, s, labels, now); err != nil {
+		switch {
+		case errors.Is(err, errTypeConflict):
+			return dropTypeConflict, err
+		case errors.Is(err, errSeriesLimit):
+			return dropSeriesLimit, err
+		default:
+			return dropInvalid, err
+		}
+	}
+
+	l.samples.WithLabelValues(instanceID).Inc()
+
+	return "", nil
+}
+
+// tagLabels extracts the instance ID from tags and converts the others into sorted labels
+// with instance_id label. Value-less tags get "true", reserved names are skipped, the last duplicate wins.
+func tagLabels(tags []tag, instanceTag string) (string, []*dto.LabelPair) {
+	var instanceID string
+	values := make(map[string]string, len(tags))
+
+	for _, t := range tags {
+		if t.key == instanceTag {
+			instanceID = t.value
+			continue
+		}
+
+		name := parser.SanitizeName(t.key)
+		if name == "" || strings.HasPrefix(name, "__") || reservedLabels[name] {
+			continue
+		}
+
+		value := t.value
+		if value == "" {
+			value = "true"
+		}
+		values[name] = value
+	}
+
+	if instanceID == "" {
+		return "", nil
+	}
+	values["instance_id"] = instanceID
+
+	labels := make([]*dto.LabelPair, 0, len(values))
+	for name, value := range values {
+		labels = append(labels, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].GetName() < labels[j].GetName()
+	})
+
+	return instanceID, labels
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ChunkStore keeps chunk data of staged transactions, metadata stays in StagingItem.
+// Storage serializes writes of one transaction, but Detach and Remove may run while a chunk is written,
+// Storage removes the transaction again after such a write. Implementations must be safe for concurrent use.
+type ChunkStore interface {
+	// Write stores the chunk, replacing a previous chunk with the same seq ID.
+	Write(txnHash string, seqID int, data []byte) error
+
+	// Detach moves chunks of the transaction out of the store, so the txn hash can be reused
+	// while the returned set is read. Closing the set releases its data.
+	Detach(txnHash string) (ChunkSet, error)
+
+	// Remove deletes chunks of the transaction.
+	Remove(txnHash string) error
+}
+
+// ChunkSet gives access to chunks of a detached transaction.
+type ChunkSet interface {
+	io.Closer
+
+	// Chunk returns a reader of the chunk data.
+	Chunk(seqID int) (io.Reader, error)
+}
+
+// MemoryChunkStore keeps chunks in memory, it is the default staging backend.
+type MemoryChunkStore struct {
+	txns map[string]memoryChunkSet
+	mu   sync.Mutex
+}
+
+// memoryChunkSet holds chunk data of one transaction by seq ID.
+type memoryChunkSet map[int][]byte
+
+// NewMemoryChunkStore creates an in-memory chunk store.
+func NewMemoryChunkStore() *MemoryChunkStore {
+	return &MemoryChunkStore{txns: make(map[string]memoryChunkSet)}
+}
+
+// Write implements ChunkStore.
+func (m *MemoryChunkStore) Write(txnHash string, seqID int, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	set, ok := m.txns[txnHash]
+	if !ok {
+		set = make(memoryChunkSet)
+		m.txns[txnHash] = set
+	}
+	set[seqID] = data
+
+	return nil
+}
+
+// Detach implements ChunkStore.
+func (m *MemoryChunkStore) Detach(txnHash string) (ChunkSet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	set := m.txns[txnHash]
+	delete(m.txns, txnHash)
+
+	return set, nil
+}
+
+// Remove implements ChunkStore.
+func (m *MemoryChunkStore) Remove(txnHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.txns, txnHash)
+	return nil
+}
+
+// Chunk implements ChunkSet.
+func (set memoryChunkSet) Chunk(seqID int) (io.Reader, error) {
+	data, ok := set[seqID]
+	if !ok {
+		return nil, fmt.Errorf("chunk %d not found", seqID)
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+// Close implements ChunkSet.
+func (set memoryChunkSet) Close() error {
+	return nil
+}
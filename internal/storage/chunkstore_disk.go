@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// File name suffixes of the disk staging backend.
+const (
+	diskTxnSuffix      = ".txn"
+	diskDetachedSuffix = ".commit"
+	diskTempSuffix     = ".tmp"
+)
+
+// DiskChunkStore spills chunks to one file per chunk in a directory per transaction.
+// A chunk is written to a temporary file and renamed over the previous one, so rewritten
+// chunks replace their data on disk. Files are opened only for the duration of a write
+// or a commit read, so they can be renamed and removed on every platform.
+type DiskChunkStore struct {
+	dir     string
+	counter atomic.Uint64
+	mu      sync.Mutex
+}
+
+// NewDiskChunkStore creates a disk chunk store in dir (OS temp dir if empty).
+// Leftover transaction files of a previous run are removed, their metadata is lost anyway.
+func NewDiskChunkStore(dir string) (*DiskChunkStore, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "metricz-staging")
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create staging dir: %w", err)
+	}
+
+	for _, pattern := range []string{"*" + diskTxnSuffix, "*" + diskDetachedSuffix, "*" + diskTempSuffix} {
+		leftovers, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range leftovers {
+			if err := os.RemoveAll(path); err != nil {
+				return nil, fmt.Errorf("failed to remove stale staging file: %w", err)
+			}
+		}
+	}
+
+	return &DiskChunkStore{dir: dir}, nil
+}
+
+// Write implements ChunkStore. Data is written without holding the store lock,
+// only moving the finished file into the transaction directory is serialized.
+func (d *DiskChunkStore) Write(txnHash string, seqID int, data []byte) error {
+	tmp := filepath.Join(d.dir, strconv.FormatUint(d.counter.Add(1), 10)+diskTempSuffix)
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	txnDir := d.txnDir(txnHash)
+	_, statErr := os.Stat(txnDir)
+	created := errors.Is(statErr, os.ErrNotExist)
+
+	if err := os.MkdirAll(txnDir, 0o700); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(txnDir, strconv.Itoa(seqID))); err != nil {
+		_ = os.Remove(tmp)
+		// Directory of a failed first chunk would otherwise stay until restart
+		if created {
+			_ = os.RemoveAll(txnDir)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Detach implements ChunkStore.
+func (d *DiskChunkStore) Detach(txnHash string) (ChunkSet, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	txnDir := d.txnDir(txnHash)
+	path := txnDir + "." + strconv.FormatUint(d.counter.Add(1), 10) + diskDetachedSuffix
+	if err := os.Rename(txnDir, path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("transaction %q has no staged chunks", txnHash)
+		}
+		_ = os.RemoveAll(txnDir)
+		return nil, err
+	}
+
+	return &diskChunkSet{path: path}, nil
+}
+
+// Remove implements ChunkStore.
+func (d *DiskChunkStore) Remove(txnHash string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return os.RemoveAll(d.txnDir(txnHash))
+}
+
+// txnDir returns the directory of the transaction chunks.
+func (d *DiskChunkStore) txnDir(txnHash string) string {
+	sum := sha256.Sum256([]byte(txnHash))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:16])+diskTxnSuffix)
+}
+
+// diskChunkSet reads chunks of a detached transaction directory, the directory is removed on Close.
+type diskChunkSet struct {
+	path    string
+	readers []*diskChunkReader
+}
+
+// Chunk implements ChunkSet. The chunk file is opened on first read and closed at its end,
+// so a transaction of many chunks does not hold a file descriptor per chunk.
+func (set *diskChunkSet) Chunk(seqID int) (io.Reader, error) {
+	path := filepath.Join(set.path, strconv.Itoa(seqID))
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("chunk %d not found: %w", seqID, err)
+	}
+
+	r := &diskChunkReader{path: path}
+	set.readers = append(set.readers, r)
+
+	return r, nil
+}
+
+// Close implements ChunkSet.
+func (set *diskChunkSet) Close() error {
+	for _, r := range set.readers {
+		r.close()
+	}
+	set.readers = nil
+
+	return os.RemoveAll(set.path)
+}
+
+// diskChunkReader lazily reads one chunk file.
+type diskChunkReader struct {
+	file *os.File
+	path string
+	done bool
+}
+
+// Read implements io.Reader.
+func (r *diskChunkReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+
+	if r.file == nil {
+		f, err := os.Open(r.path)
+		if err != nil {
+			return 0, err
+		}
+		r.file = f
+	}
+
+	n, err := r.file.Read(p)
+	if err == io.EOF {
+		r.close()
+	}
+
+	return n, err
+}
+
+func (r *diskChunkReader) close() {
+	r.done = true
+	if r.file != nil {
+		_ = r.file.Close()
+		r.file = nil
+	}
+}
@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"fmt"
+	"math"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -15,17 +18,23 @@ type Exporter struct {
 	descIngestBytes   *prometheus.Desc
 	descIngestChunks  *prometheus.Desc
 	descIngestExpired *prometheus.Desc
+	descIngestEvicted *prometheus.Desc
+	descStagingBytes  *prometheus.Desc
+	descStagingTxns   *prometheus.Desc
+	descCompressed    *prometheus.Desc
+	descDecompressed  *prometheus.Desc
+	descRejected      *prometheus.Desc
+	descCommitFailed  *prometheus.Desc
+	descForbidden     *prometheus.Desc
+	descRemoved       *prometheus.Desc
 	descLastIngest    *prometheus.Desc
-	staleMultiplier   float64
-	minStaleAge       time.Duration
+	stale             atomic.Pointer[config.StaleConfig]
 }
 
 // NewExporter creates a Prometheus collector for the internal storage state.
 func NewExporter(s *Storage, staleCfg config.StaleConfig) *Exporter {
-	return &Exporter{
-		store:           s,
-		staleMultiplier: staleCfg.StaleMultiplier,
-		minStaleAge:     staleCfg.MinStaleAge.ToDuration(),
+	e := &Exporter{
+		store: s,
 		descIngestBytes: prometheus.NewDesc(
 			"metricz_ingest_bytes_total",
 			"Total bytes received from the instance via ingest API.",
@@ -41,12 +50,65 @@ func NewExporter(s *Storage, staleCfg config.StaleConfig) *Exporter {
 			"Total chunked transactions dropped due to TTL expiration.",
 			[]string{"instance_id"}, nil,
 		),
+		descIngestEvicted: prometheus.NewDesc(
+			"metricz_ingest_transactions_evicted_total",
+			"Total chunked transactions evicted from the full staging buffer in favor of lighter instances.",
+			[]string{"instance_id"}, nil,
+		),
+		descStagingBytes: prometheus.NewDesc(
+			"metricz_ingest_staging_bytes",
+			"Bytes of incomplete chunked transactions currently staged for the instance.",
+			[]string{"instance_id"}, nil,
+		),
+		descStagingTxns: prometheus.NewDesc(
+			"metricz_ingest_staging_transactions",
+			"Number of incomplete chunked transactions currently staged for the instance.",
+			[]string{"instance_id"}, nil,
+		),
+		descCompressed: prometheus.NewDesc(
+			"metricz_ingest_compressed_bytes_total",
+			"Total compressed bytes received from the instance via ingest API (as sent on the wire).",
+			[]string{"instance_id"}, nil,
+		),
+		descDecompressed: prometheus.NewDesc(
+			"metricz_ingest_decompressed_bytes_total",
+			"Total bytes of compressed ingest requests after decompression.",
+			[]string{"instance_id"}, nil,
+		),
+		descRejected: prometheus.NewDesc(
+			"metricz_ingest_rejected_series_total",
+			"Total ingested series rejected by cardinality limits.",
+			[]string{"instance_id", "reason"}, nil,
+		),
+		descCommitFailed: prometheus.NewDesc(
+			"metricz_ingest_commit_failures_total",
+			"Total chunked transaction commits rejected by integrity checks.",
+			[]string{"instance_id", "reason"}, nil,
+		),
+		descForbidden: prometheus.NewDesc(
+			"metricz_ingest_forbidden_requests_total",
+			"Total ingest requests rejected because the instance_id is not allowed.",
+			nil, nil,
+		),
+		descRemoved: prometheus.NewDesc(
+			"metricz_instances_removed_total",
+			"Total instances removed for inactivity (expired) or via API (deleted).",
+			[]string{"reason"}, nil,
+		),
 		descLastIngest: prometheus.NewDesc(
 			"metricz_ingest_last_timestamp_seconds",
 			"Unix timestamp of the last successful ingest.",
 			[]string{"instance_id"}, nil,
 		),
 	}
+	e.UpdateStaleConfig(staleCfg)
+
+	return e
+}
+
+// UpdateStaleConfig swaps staleness settings on config reload.
+func (e *Exporter) UpdateStaleConfig(staleCfg config.StaleConfig) {
+	e.stale.Store(&staleCfg)
 }
 
 // Describe implements prometheus.Collector.
@@ -54,6 +116,15 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.descIngestBytes
 	ch <- e.descIngestChunks
 	ch <- e.descIngestExpired
+	ch <- e.descIngestEvicted
+	ch <- e.descStagingBytes
+	ch <- e.descStagingTxns
+	ch <- e.descCompressed
+	ch <- e.descDecompressed
+	ch <- e.descRejected
+	ch <- e.descCommitFailed
+	ch <- e.descForbidden
+	ch <- e.descRemoved
 	ch <- e.descLastIngest
 }
 
@@ -61,8 +132,19 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	// state snapshot
 	states := e.store.GetInstanceStates()
+	staging := e.store.StagingUsage()
+	stale := e.stale.Load()
 	now := time.Now()
 
+	ch <- prometheus.MustNewConstMetric(
+		e.descForbidden,
+		prometheus.CounterValue,
+		float64(e.store.ForbiddenIngest()))
+
+	expired, deleted := e.store.RemovedInstances()
+	ch <- prometheus.MustNewConstMetric(e.descRemoved, prometheus.CounterValue, float64(expired), "expired")
+	ch <- prometheus.MustNewConstMetric(e.descRemoved, prometheus.CounterValue, float64(deleted), "deleted")
+
 	for instanceID, state := range states {
 		// internal technical metrics
 		ch <- prometheus.MustNewConstMetric(
@@ -83,6 +165,43 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 			float64(state.IngestStats.ExpiredTransactions),
 			instanceID)
 
+		ch <- prometheus.MustNewConstMetric(
+			e.descIngestEvicted,
+			prometheus.CounterValue,
+			float64(state.IngestStats.EvictedTransactions),
+			instanceID)
+
+		e.emitStaging(ch, instanceID, staging[instanceID])
+		delete(staging, instanceID)
+
+		ch <- prometheus.MustNewConstMetric(
+			e.descCompressed,
+			prometheus.CounterValue,
+			float64(state.IngestStats.CompressedBytes),
+			instanceID)
+
+		ch <- prometheus.MustNewConstMetric(
+			e.descDecompressed,
+			prometheus.CounterValue,
+			float64(state.IngestStats.DecompressedBytes),
+			instanceID)
+
+		for reason, count := range state.IngestStats.RejectedSeries {
+			ch <- prometheus.MustNewConstMetric(
+				e.descRejected,
+				prometheus.CounterValue,
+				float64(count),
+				instanceID, reason)
+		}
+
+		for reason, count := range state.IngestStats.CommitFailures {
+			ch <- prometheus.MustNewConstMetric(
+				e.descCommitFailed,
+				prometheus.CounterValue,
+				float64(count),
+				instanceID, reason)
+		}
+
 		if !state.IngestStats.LastIngest.IsZero() {
 			ch <- prometheus.MustNewConstMetric(
 				e.descLastIngest,
@@ -91,7 +210,7 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 				instanceID)
 		}
 
-		// Ingest/A2S/RCon
+		// Ingest/A2S/RCon/StatsD
 		if state.PolledFamilies != nil {
 			e.emitFamilies(ch, state.PolledFamilies)
 		}
@@ -101,13 +220,16 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 		if state.RConFamilies != nil {
 			e.emitFamilies(ch, state.RConFamilies)
 		}
+		if state.StatsDFamilies != nil {
+			e.emitFamilies(ch, state.StatsDFamilies)
+		}
 
 		// state metric
 		if state.IngestedFamilies != nil {
 			timeSince := now.Sub(state.LastIngestUpdate)
 
-			calcThreshold := time.Duration(state.ScrapeInterval * e.staleMultiplier * float64(time.Second))
-			threshold := max(calcThreshold, e.minStaleAge)
+			calcThreshold := time.Duration(state.ScrapeInterval * stale.StaleMultiplier * float64(time.Second))
+			threshold := max(calcThreshold, stale.MinStaleAge.ToDuration())
 
 			if timeSince > threshold {
 				log.Warn().
@@ -121,39 +243,38 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 					e.emitStatusZero(ch, state.CachedStatusFamily)
 				}
 			} else {
-				e.emitFamilies(ch, state.IngestedFamilies)
+				e.emitFamilies(ch, state.ActiveIngestedFamilies(now, stale.FamilyMaxAge.ToDuration()))
 			}
 		}
 	}
+
+	// Instances staging their first transaction have no state yet
+	for instanceID, usage := range staging {
+		e.emitStaging(ch, instanceID, usage)
+	}
+}
+
+func (e *Exporter) emitStaging(ch chan<- prometheus.Metric, instanceID string, usage StagingUsage) {
+	ch <- prometheus.MustNewConstMetric(
+		e.descStagingBytes,
+		prometheus.GaugeValue,
+		float64(usage.Bytes),
+		instanceID)
+
+	ch <- prometheus.MustNewConstMetric(
+		e.descStagingTxns,
+		prometheus.GaugeValue,
+		float64(usage.Transactions),
+		instanceID)
 }
 
 func (e *Exporter) emitFamilies(ch chan<- prometheus.Metric, families map[string]*dto.MetricFamily) {
 	for _, family := range families {
 		for _, m := range family.Metric {
-			var labelNames []string
-			var labelValues []string
-			for _, pair := range m.Label {
-				labelNames = append(labelNames, pair.GetName())
-				labelValues = append(labelValues, pair.GetValue())
-			}
-
+			labelNames, labelValues := splitLabels(m)
 			desc := prometheus.NewDesc(family.GetName(), family.GetHelp(), labelNames, nil)
 
-			var val float64
-			var valType prometheus.ValueType
-
-			switch family.GetType() {
-			case dto.MetricType_GAUGE:
-				val = m.GetGauge().GetValue()
-				valType = prometheus.GaugeValue
-			case dto.MetricType_COUNTER:
-				val = m.GetCounter().GetValue()
-				valType = prometheus.CounterValue
-			default:
-				continue
-			}
-
-			metric, err := prometheus.NewConstMetric(desc, valType, val, labelValues...)
+			metric, err := newConstMetric(desc, family.GetType(), m, labelValues)
 			if err == nil {
 				ch <- metric
 			} else {
@@ -165,15 +286,136 @@ func (e *Exporter) emitFamilies(ch chan<- prometheus.Metric, families map[string
 
 func (e *Exporter) emitStatusZero(ch chan<- prometheus.Metric, family *dto.MetricFamily) {
 	for _, m := range family.Metric {
-		var labelNames []string
-		var labelValues []string
-		for _, pair := range m.Label {
-			labelNames = append(labelNames, pair.GetName())
-			labelValues = append(labelValues, pair.GetValue())
-		}
-
+		labelNames, labelValues := splitLabels(m)
 		desc := prometheus.NewDesc(family.GetName(), family.GetHelp(), labelNames, nil)
 		metric, _ := prometheus.NewConstMetric(desc, prometheus.GaugeValue, 0, labelValues...)
 		ch <- metric
 	}
 }
+
+// splitLabels returns label names and values of a series in matching order.
+func splitLabels(m *dto.Metric) ([]string, []string) {
+	labelNames := make([]string, 0, len(m.Label))
+	labelValues := make([]string, 0, len(m.Label))
+	for _, pair := range m.Label {
+		labelNames = append(labelNames, pair.GetName())
+		labelValues = append(labelValues, pair.GetValue())
+	}
+
+	return labelNames, labelValues
+}
+
+// newConstMetric converts a stored series into a const metric of the family type.
+// Created timestamps and exemplars are kept, they are exposed only when the scraper negotiates OpenMetrics.
+// GAUGE_HISTOGRAM is exposed as a regular histogram, the Prometheus text format has no such type.
+func newConstMetric(desc *prometheus.Desc, metricType dto.MetricType, m *dto.Metric, labelValues []string) (prometheus.Metric, error) {
+	var (
+		metric    prometheus.Metric
+		exemplars []prometheus.Exemplar
+		err       error
+	)
+
+	switch metricType {
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.GetGauge().GetValue(), labelValues...)
+
+	case dto.MetricType_UNTYPED:
+		return prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.GetUntyped().GetValue(), labelValues...)
+
+	case dto.MetricType_COUNTER:
+		c := m.GetCounter()
+		if c.GetCreatedTimestamp() != nil {
+			metric, err = prometheus.NewConstMetricWithCreatedTimestamp(
+				desc, prometheus.CounterValue, c.GetValue(), c.GetCreatedTimestamp().AsTime(), labelValues...)
+		} else {
+			metric, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, c.GetValue(), labelValues...)
+		}
+		if c.GetExemplar() != nil {
+			exemplars = append(exemplars, toExemplar(c.GetExemplar()))
+		}
+
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		h := m.GetHistogram()
+		buckets := make(map[float64]uint64, len(h.GetBucket()))
+		for _, b := range h.GetBucket() {
+			if b.GetExemplar() != nil {
+				exemplars = append(exemplars, toExemplar(b.GetExemplar()))
+			}
+			// +Inf bucket is implied by the sample count
+			if math.IsInf(b.GetUpperBound(), +1) {
+				continue
+			}
+			buckets[b.GetUpperBound()] = histogramBucketCount(b)
+		}
+
+		if h.GetCreatedTimestamp() != nil {
+			metric, err = prometheus.NewConstHistogramWithCreatedTimestamp(
+				desc, histogramSampleCount(h), h.GetSampleSum(), buckets, h.GetCreatedTimestamp().AsTime(), labelValues...)
+		} else {
+			metric, err = prometheus.NewConstHistogram(desc, histogramSampleCount(h), h.GetSampleSum(), buckets, labelValues...)
+		}
+
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		quantiles := make(map[float64]float64, len(s.GetQuantile()))
+		for _, q := range s.GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+
+		if s.GetCreatedTimestamp() != nil {
+			metric, err = prometheus.NewConstSummaryWithCreatedTimestamp(
+				desc, s.GetSampleCount(), s.GetSampleSum(), quantiles, s.GetCreatedTimestamp().AsTime(), labelValues...)
+		} else {
+			metric, err = prometheus.NewConstSummary(desc, s.GetSampleCount(), s.GetSampleSum(), quantiles, labelValues...)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported metric type %s", metricType)
+	}
+
+	if err != nil || len(exemplars) == 0 {
+		return metric, err
+	}
+
+	withExemplars, err := prometheus.NewMetricWithExemplars(metric, exemplars...)
+	if err != nil {
+		// Broken exemplar must not hide the series itself
+		log.Debug().Err(err).Msg("dropping invalid exemplars")
+		return metric, nil
+	}
+
+	return withExemplars, nil
+}
+
+// toExemplar converts a client model exemplar into a const metric exemplar.
+func toExemplar(e *dto.Exemplar) prometheus.Exemplar {
+	labels := make(prometheus.Labels, len(e.GetLabel()))
+	for _, lp := range e.GetLabel() {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+
+	exemplar := prometheus.Exemplar{Value: e.GetValue(), Labels: labels}
+	if e.GetTimestamp() != nil {
+		exemplar.Timestamp = e.GetTimestamp().AsTime()
+	}
+
+	return exemplar
+}
+
+// histogramSampleCount returns the sample count of integer or float histograms.
+func histogramSampleCount(h *dto.Histogram) uint64 {
+	if h.SampleCountFloat != nil {
+		return uint64(h.GetSampleCountFloat())
+	}
+
+	return h.GetSampleCount()
+}
+
+// histogramBucketCount returns the cumulative count of integer or float histogram buckets.
+func histogramBucketCount(b *dto.Bucket) uint64 {
+	if b.CumulativeCountFloat != nil {
+		return uint64(b.GetCumulativeCountFloat())
+	}
+
+	return b.GetCumulativeCount()
+}
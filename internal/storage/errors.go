@@ -1,6 +1,40 @@
 package storage
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
-// ErrStagingFull indicates that the staging buffer has reached its capacity.
-var ErrStagingFull = errors.New("staging buffer is full")
+var (
+	// ErrStagingFull indicates that the staging buffer has reached its capacity.
+	ErrStagingFull = errors.New("staging buffer is full")
+
+	// ErrInstanceStagingFull indicates that the instance has reached its staging quota.
+	ErrInstanceStagingFull = errors.New("instance staging quota exceeded")
+
+	// ErrTransactionOwner indicates that the transaction was started by another instance.
+	ErrTransactionOwner = errors.New("transaction belongs to another instance")
+
+	// ErrTransactionNotFound indicates that the transaction does not exist, expired or is empty.
+	ErrTransactionNotFound = errors.New("transaction not found or empty")
+)
+
+// Reasons of failed commit integrity checks, used as "reason" label values.
+const (
+	CommitChunkGap       = "chunk_gap"
+	CommitChunkCount     = "chunk_count"
+	CommitDigestMismatch = "digest_mismatch"
+)
+
+// IntegrityError describes a staged transaction that does not match what the commit declared.
+type IntegrityError struct {
+	Reason   string `json:"error"`
+	Message  string `json:"message"`
+	Missing  []int  `json:"missing,omitempty"`
+	Received []int  `json:"received"`
+	Expected int    `json:"expected,omitempty"`
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("commit integrity check failed (%s): %s", e.Reason, e.Message)
+}
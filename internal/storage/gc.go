@@ -7,7 +7,8 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// StartGarbageCollector runs a background loop to clean up expired staging transactions.
+// StartGarbageCollector runs a background loop to clean up expired staging transactions,
+// ingested families not updated within the family max age and inactive instances.
 func (s *Storage) StartGarbageCollector(ctx context.Context, checkInterval time.Duration) {
 	log.Info().
 		Dur("interval", checkInterval).
@@ -29,11 +30,19 @@ func (s *Storage) StartGarbageCollector(ctx context.Context, checkInterval time.
 					Int("expired_transactions", count).
 					Msg("cleaned up expired transactions with staging garbage collector")
 			}
+
+			if families := s.cleanupIngestedFamilies(); families > 0 {
+				log.Debug().
+					Int("expired_families", families).
+					Msg("cleaned up expired ingested families with garbage collector")
+			}
+
+			s.evictInstances()
 		}
 	}
 }
 
-// cleanupStaging removes expired items and returns the count of removed items.
+// cleanupStaging removes expired items and commit results, returns the count of removed items.
 func (s *Storage) cleanupStaging() int {
 	s.stagingMu.Lock()
 	defer s.stagingMu.Unlock()
@@ -47,17 +56,9 @@ func (s *Storage) cleanupStaging() int {
 
 	for key, item := range s.stagingStore {
 		if now.After(item.ExpiresAt) {
-			state, exists := s.liveStore[item.InstanceID]
-			if !exists {
-				state = &InstanceState{
-					IngestStats: IngestStats{},
-				}
-				s.liveStore[item.InstanceID] = state
-			}
-
+			state := s.getOrCreateState(item.InstanceID)
 			state.IngestStats.ExpiredTransactions++
-			s.stagingSize -= item.ByteSize
-			delete(s.stagingStore, key)
+			s.dropStaging(key, item)
 			removedCount++
 
 			log.Trace().
@@ -67,5 +68,85 @@ func (s *Storage) cleanupStaging() int {
 		}
 	}
 
+	for key, result := range s.committed {
+		if now.After(result.ExpiresAt) {
+			delete(s.committed, key)
+		}
+	}
+
+	return removedCount
+}
+
+// cleanupIngestedFamilies drops ingested families older than familyMaxAge
+// and returns the count of removed families.
+func (s *Storage) cleanupIngestedFamilies() int {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+
+	if s.familyMaxAge <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	removedCount := 0
+
+	for instanceID, state := range s.liveStore {
+		active := state.ActiveIngestedFamilies(now, s.familyMaxAge)
+		expired := len(state.IngestedFamilies) - len(active)
+		if expired == 0 {
+			continue
+		}
+
+		// Replace instead of delete, collectors may still iterate the previous map
+		updatedAt := make(map[string]time.Time, len(active))
+		for name := range active {
+			updatedAt[name] = state.IngestedAt[name]
+		}
+
+		state.IngestedFamilies = active
+		state.IngestedAt = updatedAt
+		removedCount += expired
+
+		log.Trace().
+			Str("instance_id", instanceID).
+			Int("families", expired).
+			Msg("garbage collector drop expired ingested families")
+	}
+
 	return removedCount
 }
+
+// evictInstances removes instances without activity of any source within instanceMaxAge.
+func (s *Storage) evictInstances() {
+	s.liveMu.Lock()
+
+	if s.instanceMaxAge <= 0 {
+		s.liveMu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	var evicted []string
+	for instanceID, state := range s.liveStore {
+		lastSeen := state.LastSeen()
+		if now.Sub(lastSeen) <= s.instanceMaxAge {
+			continue
+		}
+
+		delete(s.liveStore, instanceID)
+		s.expired.Add(1)
+		evicted = append(evicted, instanceID)
+
+		log.Info().
+			Str("instance_id", instanceID).
+			Time("last_seen", lastSeen).
+			Msg("inactive instance evicted")
+	}
+
+	hooks := s.removeHooks
+	s.liveMu.Unlock()
+
+	for _, instanceID := range evicted {
+		runRemoveHooks(hooks, instanceID)
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import "time"
+
+// LastSeen returns the time of the latest activity of any source: ingest, A2S, RCon or StatsD.
+// An instance without activity yet (e.g. only failed commits) is seen when it was created.
+func (st InstanceState) LastSeen() time.Time {
+	var lastSeen time.Time
+	for _, t := range []time.Time{st.LastIngestUpdate, st.LastA2SUpdate, st.LastRConUpdate, st.LastStatsDUpdate} {
+		if t.After(lastSeen) {
+			lastSeen = t
+		}
+	}
+
+	if lastSeen.IsZero() {
+		return st.CreatedAt
+	}
+
+	return lastSeen
+}
+
+// DeleteInstance removes the instance state with all its metrics and ingest stats,
+// staged transactions are kept until committed or expired. Returns false if the instance is unknown.
+// Sources still sending data (ingest, pollers, StatsD) create the instance again.
+func (s *Storage) DeleteInstance(instanceID string) bool {
+	s.liveMu.Lock()
+
+	if _, ok := s.liveStore[instanceID]; !ok {
+		s.liveMu.Unlock()
+		return false
+	}
+
+	delete(s.liveStore, instanceID)
+	s.deleted.Add(1)
+
+	hooks := s.removeHooks
+	s.liveMu.Unlock()
+
+	runRemoveHooks(hooks, instanceID)
+
+	return true
+}
+
+// OnInstanceRemoved registers fn called with the ID of every instance evicted for inactivity
+// or deleted via API, owners of per-instance series use it to drop them.
+// Hooks run outside of storage locks.
+func (s *Storage) OnInstanceRemoved(fn func(instanceID string)) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+
+	// Copy on write, removals may still run the previous slice
+	hooks := make([]func(string), 0, len(s.removeHooks)+1)
+	hooks = append(hooks, s.removeHooks...)
+	s.removeHooks = append(hooks, fn)
+}
+
+func runRemoveHooks(hooks []func(string), instanceID string) {
+	for _, fn := range hooks {
+		fn(instanceID)
+	}
+}
+
+// RemovedInstances returns the total number of instances evicted for inactivity and deleted via API.
+func (s *Storage) RemovedInstances() (expired, deleted int64) {
+	return s.expired.Load(), s.deleted.Load()
+}
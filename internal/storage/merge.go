@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// TombstoneFamily is a pseudo family of merge payloads deleting previously ingested data.
+	// Every series must have a "family" label with the target family name:
+	//   - metricz_tombstone{family="x"} deletes the whole family,
+	//   - any other labels delete only series of the family having all of them.
+	TombstoneFamily = "metricz_tombstone"
+
+	// tombstoneFamilyLabel names the target family of a tombstone series.
+	tombstoneFamilyLabel = "family"
+
+	// defaultScrapeInterval is used when the mod does not report its interval.
+	defaultScrapeInterval = 60.0
+)
+
+// MergeIngested updates only the families contained in the payload (Push, merge mode).
+// Families missing from the payload are kept and expire by their own update time,
+// tombstones are applied after the payload families are merged.
+func (s *Storage) MergeIngested(instanceID string, families map[string]*dto.MetricFamily, bytesAdded int, chunksAdded int) {
+	tombstones := families[TombstoneFamily]
+	delete(families, TombstoneFamily)
+
+	interval, hasInterval := scrapeInterval(families)
+	now := time.Now()
+
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+
+	state := s.getOrCreateState(instanceID)
+
+	// Copy on write, collectors may still iterate maps from a previous snapshot
+	merged := make(map[string]*dto.MetricFamily, len(state.IngestedFamilies)+len(families))
+	updatedAt := make(map[string]time.Time, len(state.IngestedFamilies)+len(families))
+	for name, mf := range state.IngestedFamilies {
+		merged[name] = mf
+		updatedAt[name] = state.IngestedAt[name]
+	}
+	for name, mf := range families {
+		merged[name] = mf
+		updatedAt[name] = now
+	}
+
+	if tombstones != nil {
+		applyTombstones(merged, updatedAt, tombstones)
+	}
+
+	state.IngestedFamilies = merged
+	state.IngestedAt = updatedAt
+	state.LastIngestUpdate = now
+	state.IngestStats.LastIngest = now
+	state.IngestStats.TotalBytes += int64(bytesAdded)
+	state.IngestStats.TotalChunks += int64(chunksAdded)
+
+	switch {
+	case hasInterval:
+		state.ScrapeInterval = interval
+	case state.ScrapeInterval == 0:
+		state.ScrapeInterval = defaultScrapeInterval
+	}
+
+	if statusMF, ok := families["dayz_metricz_status"]; ok {
+		state.CachedStatusFamily = statusMF
+	}
+}
+
+// applyTombstones deletes families and series matched by tombstone series.
+// Families are never modified in place, changed ones are replaced by filtered copies.
+func applyTombstones(families map[string]*dto.MetricFamily, updatedAt map[string]time.Time, tombstones *dto.MetricFamily) {
+	for _, tomb := range tombstones.Metric {
+		var (
+			target   string
+			matchers []*dto.LabelPair
+		)
+
+		for _, lp := range tomb.Label {
+			switch lp.GetName() {
+			case tombstoneFamilyLabel:
+				target = lp.GetValue()
+			case "instance_id":
+				// injected into every series of the payload, matches everything
+			default:
+				matchers = append(matchers, lp)
+			}
+		}
+
+		mf, ok := families[target]
+		if !ok {
+			continue
+		}
+
+		if len(matchers) == 0 {
+			delete(families, target)
+			delete(updatedAt, target)
+			continue
+		}
+
+		kept := make([]*dto.Metric, 0, len(mf.Metric))
+		for _, m := range mf.Metric {
+			if !hasLabels(m, matchers) {
+				kept = append(kept, m)
+			}
+		}
+
+		switch {
+		case len(kept) == 0:
+			delete(families, target)
+			delete(updatedAt, target)
+		case len(kept) != len(mf.Metric):
+			families[target] = &dto.MetricFamily{
+				Name:   mf.Name,
+				Help:   mf.Help,
+				Type:   mf.Type,
+				Unit:   mf.Unit,
+				Metric: kept,
+			}
+		}
+	}
+}
+
+// hasLabels reports whether series has all the given label pairs.
+func hasLabels(m *dto.Metric, labels []*dto.LabelPair) bool {
+	for _, want := range labels {
+		found := false
+		for _, lp := range m.Label {
+			if lp.GetName() == want.GetName() {
+				found = lp.GetValue() == want.GetValue()
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// scrapeInterval extracts the mod scrape interval reported in the payload.
+func scrapeInterval(families map[string]*dto.MetricFamily) (float64, bool) {
+	mf, ok := families["dayz_metricz_scrape_interval_seconds"]
+	if !ok || len(mf.Metric) == 0 || mf.Metric[0].Gauge == nil {
+		return 0, false
+	}
+
+	return mf.Metric[0].Gauge.GetValue(), true
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// SnapshotFile is the name of the state snapshot file in the data directory.
+	SnapshotFile = "metricz-state.json"
+
+	// snapshotVersion is the format version of the snapshot file.
+	snapshotVersion = 1
+)
+
+// snapshot is the persisted live state. Families are protojson encoded MetricFamily messages.
+type snapshot struct {
+	SavedAt   time.Time                   `json:"saved_at"`
+	Instances map[string]snapshotInstance `json:"instances"`
+	Version   int                         `json:"version"`
+}
+
+type snapshotInstance struct {
+	CreatedAt        time.Time                  `json:"created_at"`
+	LastIngestUpdate time.Time                  `json:"last_ingest_update"`
+	Families         map[string]json.RawMessage `json:"families,omitempty"`
+	IngestedAt       map[string]time.Time       `json:"ingested_at,omitempty"`
+	StatusFamily     json.RawMessage            `json:"status_family,omitempty"`
+	IngestStats      IngestStats                `json:"ingest_stats"`
+	ScrapeInterval   float64                    `json:"scrape_interval"`
+}
+
+// SaveSnapshot writes ingested families, ingest stats and update times of all instances to path.
+// The file is replaced atomically, polled and StatsD data is not persisted.
+func (s *Storage) SaveSnapshot(path string) error {
+	// Maps of a state are replaced, never modified, so the copy is safe to encode without lock
+	states := s.GetInstanceStates()
+
+	snap := snapshot{
+		Version:   snapshotVersion,
+		SavedAt:   time.Now(),
+		Instances: make(map[string]snapshotInstance, len(states)),
+	}
+
+	for instanceID, state := range states {
+		inst := snapshotInstance{
+			CreatedAt:        state.CreatedAt,
+			LastIngestUpdate: state.LastIngestUpdate,
+			IngestedAt:       state.IngestedAt,
+			IngestStats:      state.IngestStats,
+			ScrapeInterval:   state.ScrapeInterval,
+			Families:         make(map[string]json.RawMessage, len(state.IngestedFamilies)),
+		}
+
+		for name, mf := range state.IngestedFamilies {
+			data, err := protojson.Marshal(mf)
+			if err != nil {
+				return fmt.Errorf("encoding family %q of instance %q: %w", name, instanceID, err)
+			}
+			inst.Families[name] = data
+		}
+
+		if state.CachedStatusFamily != nil {
+			data, err := protojson.Marshal(state.CachedStatusFamily)
+			if err != nil {
+				return fmt.Errorf("encoding status family of instance %q: %w", instanceID, err)
+			}
+			inst.StatusFamily = data
+		}
+
+		snap.Instances[instanceID] = inst
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("encoding snapshot: %w", err)
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// LoadSnapshot restores instance states saved by SaveSnapshot and returns the number of restored instances.
+// A missing file is not an error. Update times are kept as saved, so restored families are
+// still subject to staleness detection and family expiration and never exported as fresh.
+func (s *Storage) LoadSnapshot(path string) (int, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from config
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return 0, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	states := make(map[string]*InstanceState, len(snap.Instances))
+	for instanceID, inst := range snap.Instances {
+		state := &InstanceState{
+			CreatedAt:        inst.CreatedAt,
+			LastIngestUpdate: inst.LastIngestUpdate,
+			IngestedAt:       inst.IngestedAt,
+			IngestStats:      inst.IngestStats,
+			ScrapeInterval:   inst.ScrapeInterval,
+		}
+
+		if len(inst.Families) > 0 {
+			state.IngestedFamilies = make(map[string]*dto.MetricFamily, len(inst.Families))
+		}
+		for name, raw := range inst.Families {
+			mf := &dto.MetricFamily{}
+			if err := protojson.Unmarshal(raw, mf); err != nil {
+				return 0, fmt.Errorf("decoding family %q of instance %q: %w", name, instanceID, err)
+			}
+			state.IngestedFamilies[name] = mf
+		}
+
+		if inst.StatusFamily != nil {
+			mf := &dto.MetricFamily{}
+			if err := protojson.Unmarshal(inst.StatusFamily, mf); err != nil {
+				return 0, fmt.Errorf("decoding status family of instance %q: %w", instanceID, err)
+			}
+			state.CachedStatusFamily = mf
+		}
+
+		states[instanceID] = state
+	}
+
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+
+	for instanceID, state := range states {
+		s.liveStore[instanceID] = state
+	}
+
+	return len(states), nil
+}
+
+// StartSnapshotter periodically saves the live state to path until ctx is canceled.
+// The final snapshot on shutdown is left to the caller, after ingest has stopped.
+func (s *Storage) StartSnapshotter(ctx context.Context, path string, interval time.Duration) {
+	log.Info().
+		Str("path", path).
+		Dur("interval", interval).
+		Msg("starting state snapshotter")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug().Msg("stopping state snapshotter")
+			return
+
+		case <-ticker.C:
+			start := time.Now()
+			if err := s.SaveSnapshot(path); err != nil {
+				log.Error().Err(err).Str("path", path).Msg("failed to save state snapshot")
+				continue
+			}
+
+			log.Debug().
+				Str("path", path).
+				Dur("duration_ms", time.Since(start)).
+				Msg("state snapshot saved")
+		}
+	}
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and renames it over path,
+// a crash never leaves a truncated snapshot behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
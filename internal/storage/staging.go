@@ -2,24 +2,112 @@ package storage
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"sort"
+	"sync"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
-// StagingItem holds the buffer and metadata for an ongoing transaction.
+// StagingItem holds metadata for an ongoing transaction, chunk data is kept by ChunkStore.
 type StagingItem struct {
+	CreatedAt  time.Time
 	ExpiresAt  time.Time
-	Chunks     map[int][]byte
+	Chunks     map[int]ChunkInfo
 	InstanceID string
 	ByteSize   int64
+
+	// writeMu serializes chunk writes of the transaction, they run outside of stagingMu
+	writeMu sync.Mutex
+}
+
+// ChunkInfo describes a staged chunk.
+type ChunkInfo struct {
+	Size            int64
+	EndsWithNewline bool
+}
+
+// TransactionInfo describes a staged transaction.
+type TransactionInfo struct {
+	ExpiresAt time.Time
+	SeqIDs    []int
+	ByteSize  int64
+}
+
+// CommitResult is the outcome of a committed transaction, kept to answer retried commits.
+type CommitResult struct {
+	CommittedAt time.Time
+	ExpiresAt   time.Time
+	InstanceID  string
+	Message     string
+	Status      int
+	Chunks      int
+	Bytes       int
+	Rejected    int
 }
 
 // AppendToStaging appends data.
 // It calculates TTL based on defaultTTL OR the instance's known scrape interval (whichever is larger).
 // If the transaction is new, it initializes it.
 // If the transaction exists but expired, it resets it (starts over).
-func (s *Storage) AppendToStaging(txnHash string, instanceID string, seqID int, data []byte, defaultTTL time.Duration) error {
+// quota limits staged bytes of the instance (0 for no limit), when the global staging
+// buffer is full, transactions of heavier instances are evicted to make room.
+// Chunk bytes are reserved under stagingMu and written to ChunkStore outside of it.
+func (s *Storage) AppendToStaging(txnHash string, instanceID string, seqID int, data []byte, defaultTTL time.Duration, quota int64) error {
+	item, reserved, err := s.reserveStaging(txnHash, instanceID, seqID, int64(len(data)), defaultTTL, quota)
+	if err != nil {
+		return err
+	}
+
+	item.writeMu.Lock()
+	defer item.writeMu.Unlock()
+
+	err = s.chunks.Write(txnHash, seqID, data)
+
+	s.stagingMu.Lock()
+	defer s.stagingMu.Unlock()
+
+	// Dropped while writing (expired, evicted or committed), its bytes are already released
+	if s.stagingStore[txnHash] != item {
+		if _, reused := s.stagingStore[txnHash]; !reused {
+			s.removeChunks(txnHash)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stage chunk: %w", err)
+		}
+		return ErrTransactionNotFound
+	}
+
+	item.ByteSize -= reserved
+	s.stagingSize -= reserved
+
+	if err != nil {
+		// A transaction without chunks must not hold the buffer until it expires
+		if len(item.Chunks) == 0 {
+			s.dropStaging(txnHash, item)
+		}
+		return fmt.Errorf("failed to stage chunk: %w", err)
+	}
+
+	payloadSize := int64(len(data))
+	delta := payloadSize - item.Chunks[seqID].Size
+	item.Chunks[seqID] = ChunkInfo{
+		Size:            payloadSize,
+		EndsWithNewline: payloadSize > 0 && data[payloadSize-1] == '\n',
+	}
+	item.ByteSize += delta
+	s.stagingSize += delta
+
+	return nil
+}
+
+// reserveStaging checks quota and capacity for the chunk, registers a new transaction
+// and reserves the chunk bytes. Returns the transaction and the reserved bytes.
+func (s *Storage) reserveStaging(txnHash string, instanceID string, seqID int, size int64, defaultTTL time.Duration, quota int64) (*StagingItem, int64, error) {
 	s.stagingMu.Lock()
 	defer s.stagingMu.Unlock()
 
@@ -28,25 +116,41 @@ func (s *Storage) AppendToStaging(txnHash string, instanceID string, seqID int,
 
 	// Logic: If item exists but expired -> Treat as new (Reset)
 	if exists && now.After(item.ExpiresAt) {
-		s.stagingSize -= item.ByteSize // reset quota
-		delete(s.stagingStore, txnHash)
+		s.dropStaging(txnHash, item) // reset quota
 		exists = false
 	}
 
-	payloadSize := int64(len(data))
+	// Transactions are bound to the instance that started them
+	if exists && item.InstanceID != instanceID {
+		return nil, 0, ErrTransactionOwner
+	}
 
-	if !exists {
-		if s.stagingSize+payloadSize > s.maxStagingSize {
-			return ErrStagingFull
+	// Expired transactions of the instance must not count against its quota until the GC runs,
+	// other in-progress transactions are kept
+	for key, val := range s.stagingStore {
+		if val.InstanceID == instanceID && now.After(val.ExpiresAt) {
+			s.dropStaging(key, val)
 		}
+	}
 
-		// Delete stale ingest data
-		for key, val := range s.stagingStore {
-			if val.InstanceID == instanceID {
-				s.stagingSize -= val.ByteSize
-				delete(s.stagingStore, key)
-			}
-		}
+	// Rewritten chunk only needs the growth, a shrink is released when the write completes
+	reserved := size
+	if exists {
+		reserved = max(size-item.Chunks[seqID].Size, 0)
+	}
+
+	// Capacity Checks, a new transaction is not registered until its first chunk fits
+	if quota > 0 && s.instanceStagingSize(instanceID)+reserved > quota {
+		return nil, 0, ErrInstanceStagingFull
+	}
+
+	if s.stagingSize+reserved > s.maxStagingSize && !s.evictForSpace(instanceID, reserved) {
+		return nil, 0, ErrStagingFull
+	}
+
+	if !exists {
+		// Hash reused for a new upload, the previous commit result no longer describes it
+		delete(s.committed, txnHash)
 
 		// Calculate dynamic TTL
 		ttl := defaultTTL
@@ -65,7 +169,8 @@ func (s *Storage) AppendToStaging(txnHash string, instanceID string, seqID int,
 		s.liveMu.RUnlock()
 
 		item = &StagingItem{
-			Chunks:     make(map[int][]byte),
+			Chunks:     make(map[int]ChunkInfo),
+			CreatedAt:  now,
 			ExpiresAt:  now.Add(ttl),
 			InstanceID: instanceID,
 			ByteSize:   0,
@@ -73,39 +178,63 @@ func (s *Storage) AppendToStaging(txnHash string, instanceID string, seqID int,
 		s.stagingStore[txnHash] = item
 	}
 
-	// Calculate Delta
-	delta := payloadSize
-	if oldChunk, ok := item.Chunks[seqID]; ok {
-		delta -= int64(len(oldChunk))
-	}
+	item.ByteSize += reserved
+	s.stagingSize += reserved
 
-	// Final Capacity Check
-	if s.stagingSize+delta > s.maxStagingSize {
-		return ErrStagingFull
-	}
+	return item, reserved, nil
+}
 
-	item.Chunks[seqID] = data
-	item.ByteSize += delta
-	s.stagingSize += delta
+// maxReportedMissing bounds the list of missing seq IDs returned in IntegrityError.
+const maxReportedMissing = 100
 
-	return nil
+// RetrieveOptions controls validation and assembly of a staged transaction.
+type RetrieveOptions struct {
+	// InstanceID must match the instance that started the transaction.
+	InstanceID string
+
+	// Digest is the expected SHA-256 of the chunks concatenated in seq order, nil skips the check.
+	Digest []byte
+
+	// ExpectedChunks is the declared number of chunks, 0 skips the check.
+	ExpectedChunks int
+
+	// JoinLines appends a newline to chunks not ending with one,
+	// binary payloads must be concatenated as is.
+	JoinLines bool
+}
+
+// StagedPayload is an assembled transaction ready for parsing, it must be closed after reading.
+type StagedPayload struct {
+	Reader io.Reader
+	set    ChunkSet
+	Chunks int
+	Bytes  int
+}
+
+// Close releases chunk data of the payload.
+func (p *StagedPayload) Close() error {
+	return p.set.Close()
 }
 
-// RetrieveStaging returns buffer and chunk count.
-func (s *Storage) RetrieveStaging(txnHash string) (io.Reader, int, int, bool) {
+// RetrieveStaging validates the transaction and removes it from staging.
+// Seq IDs must be contiguous, and with ExpectedChunks or Digest set also start at FirstSeqID
+// and match ExpectedChunks, otherwise *IntegrityError is returned
+// and the transaction is kept, so missing chunks can be uploaded and the commit retried.
+// On digest mismatch the transaction is dropped, its content is not trusted anymore.
+// Transactions of other instances are reported as missing and kept intact.
+func (s *Storage) RetrieveStaging(txnHash string, opts RetrieveOptions) (*StagedPayload, error) {
 	s.stagingMu.Lock()
-	defer s.stagingMu.Unlock()
 
 	item, exists := s.stagingStore[txnHash]
-	if !exists {
-		return nil, 0, 0, false
+	if !exists || item.InstanceID != opts.InstanceID {
+		s.stagingMu.Unlock()
+		return nil, ErrTransactionNotFound
 	}
 
-	s.stagingSize -= item.ByteSize
-	delete(s.stagingStore, txnHash)
-
 	if time.Now().After(item.ExpiresAt) || len(item.Chunks) == 0 {
-		return nil, 0, 0, false
+		s.dropStaging(txnHash, item)
+		s.stagingMu.Unlock()
+		return nil, ErrTransactionNotFound
 	}
 
 	keys := make([]int, 0, len(item.Chunks))
@@ -115,20 +244,184 @@ func (s *Storage) RetrieveStaging(txnHash string) (io.Reader, int, int, bool) {
 
 	sort.Ints(keys)
 
+	if err := checkSequence(keys, opts.ExpectedChunks > 0 || opts.Digest != nil, opts.ExpectedChunks); err != nil {
+		s.stagingMu.Unlock()
+		return nil, err
+	}
+
+	// Detach chunks, so reading them does not block staging of other transactions
+	set, err := s.chunks.Detach(txnHash)
+	s.stagingSize -= item.ByteSize
+	delete(s.stagingStore, txnHash)
+	s.stagingMu.Unlock()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staged chunks: %w", err)
+	}
+
+	if opts.Digest != nil {
+		if err := verifyDigest(set, keys, opts.Digest); err != nil {
+			_ = set.Close()
+			return nil, err
+		}
+	}
+
 	var totalSize int
 	var readers []io.Reader
 	newLine := []byte("\n")
 
 	for _, k := range keys {
-		chunkData := item.Chunks[k]
-		totalSize += len(chunkData)
-		readers = append(readers, bytes.NewReader(chunkData))
+		chunk, err := set.Chunk(k)
+		if err != nil {
+			_ = set.Close()
+			return nil, fmt.Errorf("failed to read staged chunks: %w", err)
+		}
 
-		if len(chunkData) > 0 && chunkData[len(chunkData)-1] != '\n' {
+		info := item.Chunks[k]
+		totalSize += int(info.Size)
+		readers = append(readers, chunk)
+
+		if opts.JoinLines && info.Size > 0 && !info.EndsWithNewline {
 			readers = append(readers, bytes.NewReader(newLine))
 			totalSize++
 		}
 	}
 
-	return io.MultiReader(readers...), len(keys), totalSize, true
+	return &StagedPayload{
+		Reader: io.MultiReader(readers...),
+		set:    set,
+		Chunks: len(keys),
+		Bytes:  totalSize,
+	}, nil
+}
+
+// verifyDigest compares SHA-256 of chunks concatenated in seq order with the expected digest.
+func verifyDigest(set ChunkSet, keys []int, digest []byte) error {
+	hash := sha256.New()
+	for _, k := range keys {
+		chunk, err := set.Chunk(k)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(hash, chunk); err != nil {
+			return err
+		}
+	}
+
+	if sum := hash.Sum(nil); !bytes.Equal(sum, digest) {
+		return &IntegrityError{
+			Reason:   CommitDigestMismatch,
+			Message:  "payload digest is sha256:" + hex.EncodeToString(sum),
+			Received: keys,
+		}
+	}
+
+	return nil
+}
+
+// TransactionInfo returns received seq IDs, size and expiration of a staged transaction.
+func (s *Storage) TransactionInfo(txnHash string, instanceID string) (TransactionInfo, bool) {
+	s.stagingMu.Lock()
+	defer s.stagingMu.Unlock()
+
+	item, exists := s.stagingStore[txnHash]
+	if !exists || item.InstanceID != instanceID || time.Now().After(item.ExpiresAt) {
+		return TransactionInfo{}, false
+	}
+
+	keys := make([]int, 0, len(item.Chunks))
+	for k := range item.Chunks {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	return TransactionInfo{
+		ExpiresAt: item.ExpiresAt,
+		SeqIDs:    keys,
+		ByteSize:  item.ByteSize,
+	}, true
+}
+
+// RecordCommit remembers the result of a committed transaction for ttl.
+func (s *Storage) RecordCommit(txnHash string, result CommitResult, ttl time.Duration) {
+	s.stagingMu.Lock()
+	defer s.stagingMu.Unlock()
+
+	result.ExpiresAt = result.CommittedAt.Add(ttl)
+	s.committed[txnHash] = &result
+}
+
+// CommitResult returns the remembered result of a committed transaction of the instance.
+func (s *Storage) CommitResult(txnHash string, instanceID string) (CommitResult, bool) {
+	s.stagingMu.Lock()
+	defer s.stagingMu.Unlock()
+
+	result, exists := s.committed[txnHash]
+	if !exists || result.InstanceID != instanceID || time.Now().After(result.ExpiresAt) {
+		return CommitResult{}, false
+	}
+
+	return *result, true
+}
+
+// dropStaging removes the transaction with its chunks and releases its staging quota.
+// Must be called under stagingMu.Lock()
+func (s *Storage) dropStaging(txnHash string, item *StagingItem) {
+	s.stagingSize -= item.ByteSize
+	delete(s.stagingStore, txnHash)
+	s.removeChunks(txnHash)
+}
+
+// removeChunks deletes chunk data of the transaction, failures are only logged.
+func (s *Storage) removeChunks(txnHash string) {
+	if err := s.chunks.Remove(txnHash); err != nil {
+		log.Warn().
+			Err(err).
+			Str("txn", txnHash).
+			Msg("failed to remove staged chunks")
+	}
+}
+
+// FirstSeqID is the seq ID of the first chunk of a transaction.
+const FirstSeqID = 0
+
+// checkSequence verifies sorted seq IDs are contiguous and match the expected count.
+// Strict sequences of clients sending integrity expectations must start at FirstSeqID,
+// others are checked from the lowest received seq ID, which keeps clients numbering from 1 working.
+// A missing last chunk is detected only by the expected count.
+func checkSequence(keys []int, strict bool, expected int) error {
+	first, last := keys[0], keys[len(keys)-1]
+	if strict {
+		first = FirstSeqID
+	}
+
+	if span := last - first + 1; span != len(keys) {
+		missing := make([]int, 0, min(span-len(keys), maxReportedMissing))
+		next := first
+		for _, k := range keys {
+			for ; next < k && len(missing) < maxReportedMissing; next++ {
+				missing = append(missing, next)
+			}
+			next = k + 1
+		}
+
+		return &IntegrityError{
+			Reason:   CommitChunkGap,
+			Message:  fmt.Sprintf("%d chunks missing between seq %d and %d", span-len(keys), first, last),
+			Missing:  missing,
+			Received: keys,
+			Expected: expected,
+		}
+	}
+
+	if expected > 0 && expected != len(keys) {
+		return &IntegrityError{
+			Reason:   CommitChunkCount,
+			Message:  fmt.Sprintf("received %d chunks, expected %d", len(keys), expected),
+			Received: keys,
+			Expected: expected,
+		}
+	}
+
+	return nil
 }
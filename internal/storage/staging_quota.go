@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// StagingUsage is the staging buffer usage of an instance.
+type StagingUsage struct {
+	Bytes        int64
+	Transactions int
+}
+
+// StagingUsage returns staging buffer usage by instance ID.
+func (s *Storage) StagingUsage() map[string]StagingUsage {
+	s.stagingMu.Lock()
+	defer s.stagingMu.Unlock()
+
+	usage := make(map[string]StagingUsage)
+	for _, item := range s.stagingStore {
+		u := usage[item.InstanceID]
+		u.Bytes += item.ByteSize
+		u.Transactions++
+		usage[item.InstanceID] = u
+	}
+
+	return usage
+}
+
+// StagingRetryAfter returns the time until the next staged transaction expires
+// and frees staging space, at least one second.
+func (s *Storage) StagingRetryAfter() time.Duration {
+	s.stagingMu.Lock()
+	defer s.stagingMu.Unlock()
+
+	now := time.Now()
+	wait := time.Duration(0)
+	for _, item := range s.stagingStore {
+		if d := item.ExpiresAt.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+
+	return max(wait, time.Second)
+}
+
+// instanceStagingSize returns bytes staged by the instance.
+// Must be called under stagingMu.Lock()
+func (s *Storage) instanceStagingSize(instanceID string) int64 {
+	var size int64
+	for _, item := range s.stagingStore {
+		if item.InstanceID == instanceID {
+			size += item.ByteSize
+		}
+	}
+
+	return size
+}
+
+// evictForSpace drops the oldest transactions of the heaviest instances until need bytes fit
+// into the staging buffer. Only instances staging more than the requester would after the write
+// are evicted, so a single instance can not take over the buffer, but can not be starved either.
+// Reports whether the space was freed.
+// Must be called under stagingMu.Lock()
+func (s *Storage) evictForSpace(instanceID string, need int64) bool {
+	if need > s.maxStagingSize {
+		return false
+	}
+
+	for s.stagingSize+need > s.maxStagingSize {
+		usage := make(map[string]int64)
+		for _, item := range s.stagingStore {
+			usage[item.InstanceID] += item.ByteSize
+		}
+
+		heaviest, heaviestSize := "", usage[instanceID]+need
+		for id, size := range usage {
+			if id != instanceID && size > heaviestSize {
+				heaviest, heaviestSize = id, size
+			}
+		}
+		if heaviest == "" {
+			return false
+		}
+
+		var oldestKey string
+		var oldest *StagingItem
+		for key, item := range s.stagingStore {
+			if item.InstanceID == heaviest && (oldest == nil || item.CreatedAt.Before(oldest.CreatedAt)) {
+				oldestKey, oldest = key, item
+			}
+		}
+
+		s.dropStaging(oldestKey, oldest)
+		s.addEvicted(heaviest)
+
+		log.Debug().
+			Str("txn", oldestKey).
+			Str("instance_id", heaviest).
+			Str("requested_by", instanceID).
+			Int64("bytes", oldest.ByteSize).
+			Msg("staging buffer full, transaction evicted")
+	}
+
+	return true
+}
+
+// addEvicted counts a transaction of the instance evicted from the staging buffer.
+func (s *Storage) addEvicted(instanceID string) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+
+	s.getOrCreateState(instanceID).IngestStats.EvictedTransactions++
+}
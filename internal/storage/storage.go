@@ -3,6 +3,7 @@ package storage
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	dto "github.com/prometheus/client_model/go"
@@ -12,48 +13,100 @@ import (
 type Storage struct {
 	liveStore      map[string]*InstanceState
 	stagingStore   map[string]*StagingItem
+	committed      map[string]*CommitResult
+	chunks         ChunkStore
+	removeHooks    []func(instanceID string)
 	stagingSize    int64
 	maxStagingSize int64
+	familyMaxAge   time.Duration
+	instanceMaxAge time.Duration
+	forbidden      atomic.Int64
+	expired        atomic.Int64
+	deleted        atomic.Int64
 	liveMu         sync.RWMutex
 	stagingMu      sync.Mutex
 }
 
 // InstanceState holds the metrics and metadata for a specific game server instance.
 type InstanceState struct {
+	CreatedAt          time.Time
 	LastIngestUpdate   time.Time
+	LastA2SUpdate      time.Time
+	LastRConUpdate     time.Time
+	LastStatsDUpdate   time.Time
 	IngestedFamilies   map[string]*dto.MetricFamily
+	IngestedAt         map[string]time.Time
 	CachedStatusFamily *dto.MetricFamily
 	PolledFamilies     map[string]*dto.MetricFamily
 	A2SFamilies        map[string]*dto.MetricFamily
 	RConFamilies       map[string]*dto.MetricFamily
+	StatsDFamilies     map[string]*dto.MetricFamily
 	IngestStats        IngestStats
 	ScrapeInterval     float64
 }
 
 // IngestStats holds technical statistics about data ingestion.
 type IngestStats struct {
-	LastIngest          time.Time
-	TotalBytes          int64
-	TotalChunks         int64
-	ExpiredTransactions int64
+	LastIngest          time.Time        `json:"last_ingest"`
+	RejectedSeries      map[string]int64 `json:"rejected_series,omitempty"`
+	CommitFailures      map[string]int64 `json:"commit_failures,omitempty"`
+	TotalBytes          int64            `json:"total_bytes"`
+	TotalChunks         int64            `json:"total_chunks"`
+	ExpiredTransactions int64            `json:"expired_transactions"`
+	EvictedTransactions int64            `json:"evicted_transactions"`
+	CompressedBytes     int64            `json:"compressed_bytes"`
+	DecompressedBytes   int64            `json:"decompressed_bytes"`
 }
 
 // New creates a new Storage.
-func New(maxStagingSize int64) *Storage {
+// familyMaxAge expires ingested families not updated for longer, 0 disables expiration.
+// instanceMaxAge evicts instances without activity of any source for longer, 0 disables eviction.
+// chunks keeps data of staged transactions, nil uses MemoryChunkStore.
+func New(maxStagingSize int64, familyMaxAge, instanceMaxAge time.Duration, chunks ChunkStore) *Storage {
+	if chunks == nil {
+		chunks = NewMemoryChunkStore()
+	}
+
 	return &Storage{
+		chunks:         chunks,
 		liveStore:      make(map[string]*InstanceState),
 		stagingStore:   make(map[string]*StagingItem),
+		committed:      make(map[string]*CommitResult),
 		maxStagingSize: maxStagingSize,
+		familyMaxAge:   familyMaxAge,
+		instanceMaxAge: instanceMaxAge,
 	}
 }
 
+// SetLimits replaces the staging buffer size, family and instance max age on config reload.
+// Already staged transactions over a smaller buffer are kept until committed or expired.
+func (s *Storage) SetLimits(maxStagingSize int64, familyMaxAge, instanceMaxAge time.Duration) {
+	s.stagingMu.Lock()
+	defer s.stagingMu.Unlock()
+
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+
+	s.maxStagingSize = maxStagingSize
+	s.familyMaxAge = familyMaxAge
+	s.instanceMaxAge = instanceMaxAge
+}
+
 // UpdateIngested updates the metrics received from the mod (Push).
+// The payload replaces all previously ingested families of the instance.
 func (s *Storage) UpdateIngested(instanceID string, families map[string]*dto.MetricFamily, bytesAdded int, chunksAdded int) {
-	interval := 60.0
-	if mf, ok := families["dayz_metricz_scrape_interval_seconds"]; ok {
-		if len(mf.Metric) > 0 && mf.Metric[0].Gauge != nil {
-			interval = mf.Metric[0].Gauge.GetValue()
-		}
+	// Tombstones only make sense for merge, a full snapshot has nothing to delete
+	delete(families, TombstoneFamily)
+
+	interval, ok := scrapeInterval(families)
+	if !ok {
+		interval = defaultScrapeInterval
+	}
+
+	now := time.Now()
+	updatedAt := make(map[string]time.Time, len(families))
+	for name := range families {
+		updatedAt[name] = now
 	}
 
 	s.liveMu.Lock()
@@ -61,7 +114,8 @@ func (s *Storage) UpdateIngested(instanceID string, families map[string]*dto.Met
 
 	state := s.getOrCreateState(instanceID)
 	state.IngestedFamilies = families
-	state.LastIngestUpdate = time.Now()
+	state.IngestedAt = updatedAt
+	state.LastIngestUpdate = now
 	state.ScrapeInterval = interval
 	state.IngestStats.LastIngest = time.Now()
 	state.IngestStats.TotalBytes += int64(bytesAdded)
@@ -72,13 +126,91 @@ func (s *Storage) UpdateIngested(instanceID string, families map[string]*dto.Met
 	}
 }
 
+// UpdateIngestEncoding accounts a compressed request body received from the instance.
+func (s *Storage) UpdateIngestEncoding(instanceID string, compressed, decompressed int64) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+
+	state := s.getOrCreateState(instanceID)
+	state.IngestStats.CompressedBytes += compressed
+	state.IngestStats.DecompressedBytes += decompressed
+}
+
+// AddRejectedSeries accounts ingested series rejected by cardinality limits.
+func (s *Storage) AddRejectedSeries(instanceID string, reason string, count int) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+
+	state := s.getOrCreateState(instanceID)
+	state.IngestStats.RejectedSeries = addReason(state.IngestStats.RejectedSeries, reason, int64(count))
+}
+
+// AddCommitFailure accounts a commit rejected by transaction integrity checks.
+func (s *Storage) AddCommitFailure(instanceID string, reason string) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+
+	state := s.getOrCreateState(instanceID)
+	state.IngestStats.CommitFailures = addReason(state.IngestStats.CommitFailures, reason, 1)
+}
+
+// addReason returns a copy of counters with delta added to reason.
+// Copy on write, collectors may still iterate the previous map.
+func addReason(counters map[string]int64, reason string, delta int64) map[string]int64 {
+	updated := make(map[string]int64, len(counters)+1)
+	for k, v := range counters {
+		updated[k] = v
+	}
+	updated[reason] += delta
+
+	return updated
+}
+
+// AddForbiddenIngest accounts an ingest request rejected for an instance ID that is not allowed.
+// Such requests never create instance state, so the counter is global.
+func (s *Storage) AddForbiddenIngest() {
+	s.forbidden.Add(1)
+}
+
+// ForbiddenIngest returns the total number of ingest requests rejected for not allowed instance IDs.
+func (s *Storage) ForbiddenIngest() int64 {
+	return s.forbidden.Load()
+}
+
+// IngestedSeries returns the number of stored ingested series per family of the instance.
+func (s *Storage) IngestedSeries(instanceID string) map[string]int {
+	s.liveMu.RLock()
+	defer s.liveMu.RUnlock()
+
+	state, ok := s.liveStore[instanceID]
+	if !ok {
+		return nil
+	}
+
+	series := make(map[string]int, len(state.IngestedFamilies))
+	for name, mf := range state.IngestedFamilies {
+		series[name] = len(mf.Metric)
+	}
+
+	return series
+}
+
 // UpdatePolled updates the metrics collected by the exporter itself (A2S/RCon).
+// Nil families of a stopped poller drop the metrics and never recreate a removed instance.
 func (s *Storage) UpdatePolled(instanceID string, families map[string]*dto.MetricFamily) {
 	s.liveMu.Lock()
 	defer s.liveMu.Unlock()
 
+	if families == nil {
+		if state, ok := s.liveStore[instanceID]; ok {
+			state.PolledFamilies = nil
+		}
+		return
+	}
+
 	state := s.getOrCreateState(instanceID)
 	state.PolledFamilies = families
+	state.LastA2SUpdate = time.Now()
 }
 
 // UpdateA2S stores A2S metrics for instance.
@@ -90,13 +222,45 @@ func (s *Storage) UpdateA2S(instanceID string, families map[string]*dto.MetricFa
 	state.A2SFamilies = families
 }
 
-// UpdateRCon stores RCon metrics for instance.
+// UpdateRCon stores RCon metrics for instance, nil families drop them.
 func (s *Storage) UpdateRCon(instanceID string, families map[string]*dto.MetricFamily) {
 	s.liveMu.Lock()
 	defer s.liveMu.Unlock()
 
+	if families == nil {
+		if state, ok := s.liveStore[instanceID]; ok {
+			state.RConFamilies = nil
+		}
+		return
+	}
+
 	state := s.getOrCreateState(instanceID)
 	state.RConFamilies = families
+	state.LastRConUpdate = time.Now()
+}
+
+// UpdateStatsD stores families aggregated from StatsD samples of the instance and the time
+// of its last sample, nil families (all series expired) drop them.
+func (s *Storage) UpdateStatsD(instanceID string, families map[string]*dto.MetricFamily, lastSample time.Time) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+
+	state, ok := s.liveStore[instanceID]
+	if families == nil {
+		if ok {
+			state.StatsDFamilies = nil
+		}
+		return
+	}
+
+	// Aggregated series outlive an evicted instance, they must not bring it back
+	if !ok && s.instanceMaxAge > 0 && time.Since(lastSample) > s.instanceMaxAge {
+		return
+	}
+
+	state = s.getOrCreateState(instanceID)
+	state.StatsDFamilies = families
+	state.LastStatsDUpdate = lastSample
 }
 
 // getOrCreateState is a helper to ensure instance state exists.
@@ -105,6 +269,7 @@ func (s *Storage) getOrCreateState(instanceID string) *InstanceState {
 	state, exists := s.liveStore[instanceID]
 	if !exists {
 		state = &InstanceState{
+			CreatedAt:   time.Now(),
 			IngestStats: IngestStats{},
 		}
 		s.liveStore[instanceID] = state
@@ -125,3 +290,20 @@ func (s *Storage) GetInstanceStates() map[string]InstanceState {
 
 	return result
 }
+
+// ActiveIngestedFamilies returns ingested families updated within maxAge.
+// Zero maxAge disables family expiration and returns all ingested families.
+func (st InstanceState) ActiveIngestedFamilies(now time.Time, maxAge time.Duration) map[string]*dto.MetricFamily {
+	if maxAge <= 0 || st.IngestedFamilies == nil {
+		return st.IngestedFamilies
+	}
+
+	active := make(map[string]*dto.MetricFamily, len(st.IngestedFamilies))
+	for name, mf := range st.IngestedFamilies {
+		if now.Sub(st.IngestedAt[name]) <= maxAge {
+			active[name] = mf
+		}
+	}
+
+	return active
+}